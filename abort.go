@@ -0,0 +1,72 @@
+package viascan
+
+import (
+	"fmt"
+	"sync"
+)
+
+// errorBudget tracks whether recent sites failed so that a scan can be
+// abandoned when something systemic (dead resolver, blocked egress)
+// means every result is worthless.
+type errorBudget struct {
+	sync.Mutex
+
+	rate   float64 // Error rate at or above which the scan aborts
+	window []bool  // Ring buffer of recent results (true means error)
+	next   int     // Next slot in window to fill
+	filled int     // Number of slots in window that have been filled
+	errors int     // Number of errors currently in window
+
+	tripped chan struct{} // Closed when the budget has been exceeded
+	reason  string        // Description of why the budget was exceeded
+}
+
+// newErrorBudget creates an errorBudget that trips when the error rate
+// over the last size sites reaches rate. A rate of 0 disables it.
+func newErrorBudget(rate float64, size int) *errorBudget {
+	return &errorBudget{rate: rate, window: make([]bool, size),
+		tripped: make(chan struct{})}
+}
+
+// failed returns true if looking up the site's name or the request
+// with no Via was tried and did not work. Sites that were skipped (see
+// -skip-known-dead and -scope) or only failed with Via are not
+// failures: those are results, not signs of a broken scan.
+func (s *site) failed() bool {
+	return (s.resolves.ran && !s.resolves.yesno) ||
+		(s.noVia.ran && !s.noVia.yesno)
+}
+
+// record adds the outcome for a site to the window and trips the
+// budget if the error rate is sustained over a full window
+func (b *errorBudget) record(s *site) {
+	if b.rate <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.filled == len(b.window) && b.window[b.next] {
+		b.errors--
+	}
+	b.window[b.next] = s.failed()
+	if b.window[b.next] {
+		b.errors++
+	}
+	b.next = (b.next + 1) % len(b.window)
+	if b.filled < len(b.window) {
+		b.filled++
+	}
+
+	if b.filled < len(b.window) || b.reason != "" {
+		return
+	}
+
+	r := float64(b.errors) / float64(b.filled)
+	if r >= b.rate {
+		b.reason = fmt.Sprintf("error rate %.2f over last %d sites reached %.2f",
+			r, b.filled, b.rate)
+		close(b.tripped)
+	}
+}
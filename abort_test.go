@@ -0,0 +1,51 @@
+package viascan
+
+import "testing"
+
+func TestFailed(t *testing.T) {
+	yes, no := tri{true, true}, tri{true, false}
+	tests := []struct {
+		name string
+		s    site
+		want bool
+	}{
+		{"skipped", site{}, false},
+		{"unresolved", site{resolves: no}, true},
+		{"out of scope", site{resolves: yes}, false},
+		{"failed", site{resolves: yes, noVia: no}, true},
+		{"blocked", site{resolves: yes, noVia: yes, via: no}, false},
+		{"worked", site{resolves: yes, noVia: yes, via: yes}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.s.failed(); got != tt.want {
+			t.Errorf("%s: failed() is %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Only failures count towards the error rate and the budget trips once
+// a full window reaches it
+func TestErrorBudget(t *testing.T) {
+	b := newErrorBudget(0.5, 4)
+	fail := &site{resolves: tri{true, false}}
+	skip := &site{}
+	for _, s := range []*site{fail, skip, skip, fail} {
+		b.record(s)
+	}
+	select {
+	case <-b.tripped:
+	default:
+		t.Fatalf("budget did not trip with 2 failures in 4")
+	}
+
+	b = newErrorBudget(0.5, 4)
+	for _, s := range []*site{fail, skip, skip, skip, skip} {
+		b.record(s)
+	}
+	select {
+	case <-b.tripped:
+		t.Fatalf("budget tripped: %s", b.reason)
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// acceptEncoding returns the Accept-Encoding sent to origins
+func (cfg *config) acceptEncoding() string {
+	return strings.Join(append([]string{"gzip", "deflate"},
+		cfg.extraEncodings...), ",")
+}
+
+// aeOrders are the Accept-Encoding values sent by -ae-order. They
+// differ only in the order of the encodings and the whitespace.
+var aeOrders = []string{"gzip,deflate", "deflate,gzip", "gzip, deflate",
+	"deflate, gzip"}
+
+// aeMatrixValues are the Accept-Encoding values sent by -ae-matrix,
+// the first being no Accept-Encoding header at all
+var aeMatrixValues = []string{"", "identity", "gzip", "br",
+	"gzip,deflate,br"}
+
+// encodingsFor sends req once for each of the Accept-Encoding values
+// in aes and returns the Content-Encoding chosen for each (none if
+// there was none, ! if the request failed) joined with /
+func (s *site) encodingsFor(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request,
+	aes []string) (string, bool) {
+	chosen := make([]string, len(aes))
+	for i, ae := range aes {
+		r := req.Clone(req.Context())
+		r.Header.Set("Accept-Encoding", ae)
+		if ae == "" {
+			r.Header.Del("Accept-Encoding")
+		}
+		resp, err := s.fetch(l, client, transport, r)
+		switch {
+		case err != nil:
+			chosen[i] = "!"
+		case resp.encoding == "":
+			chosen[i] = "none"
+		default:
+			chosen[i] = resp.encoding
+		}
+	}
+
+	same := true
+	for _, c := range chosen {
+		same = same && c == chosen[0]
+	}
+
+	return strings.Join(chosen, "/"), !same
+}
+
+// testAEOrder checks whether the encoding an origin chooses depends on
+// the order of or whitespace in Accept-Encoding, with and without Via
+func (s *site) testAEOrder(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request) {
+	noVia := req.Clone(req.Context())
+	noVia.Header.Del("Via")
+	var noViaSensitive, viaSensitive bool
+	s.noViaAEOrder, noViaSensitive = s.encodingsFor(l, client, transport,
+		noVia, aeOrders)
+	s.viaAEOrder, viaSensitive = s.encodingsFor(l, client, transport, req,
+		aeOrders)
+	s.aeOrderViaOnly = tri{ran: true, yesno: viaSensitive && !noViaSensitive}
+}
+
+// testAEMatrix records the encoding an origin chooses for each of
+// aeMatrixValues with and without Via, and whether any of them differ
+func (s *site) testAEMatrix(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request) {
+	noVia := req.Clone(req.Context())
+	noVia.Header.Del("Via")
+	s.noViaAEMatrix, _ = s.encodingsFor(l, client, transport, noVia,
+		aeMatrixValues)
+	s.viaAEMatrix, _ = s.encodingsFor(l, client, transport, req,
+		aeMatrixValues)
+	s.aeMatrixDiffers = tri{ran: true,
+		yesno: s.noViaAEMatrix != s.viaAEMatrix}
+}
@@ -0,0 +1,113 @@
+package viascan
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// aggregations are the ways the aggregate subcommand can group sites:
+// by the product in their Server header (see site.stack) or the public
+// suffix of their origin
+var aggregations = map[string]func(s *site) string{
+	"server": func(s *site) string { return s.stack() },
+	"tld":    func(s *site) string { return tld(s.origin) },
+}
+
+// aggregateRow counts the sites in a group by verdict
+type aggregateRow struct {
+	group    string
+	sites    int
+	verdicts map[string]int
+}
+
+// tested returns the number of sites in the row whose request with no
+// Via worked, which are the ones whose behavior with Via is known
+func (r *aggregateRow) tested() int {
+	return r.sites - r.verdicts["unresolved"] - r.verdicts["failed"] -
+		r.verdicts["allowlisted"]
+}
+
+// percent returns the percentage of the tested sites in the row with
+// verdict
+func (r *aggregateRow) percent(verdict string) float64 {
+	if r.tested() == 0 {
+		return 0
+	}
+	return 100 * float64(r.verdicts[verdict]) / float64(r.tested())
+}
+
+// aggregateCommand implements the aggregate subcommand which writes a
+// table of how each group of sites (e.g. each Server software family)
+// behaves with Via, as the percentage of the sites that could be
+// tested whose encoding or size differed or that blocked Via
+func aggregateCommand(args []string) int {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	by := fs.String("by", "server", "Group sites by server or tld")
+	minSites := fs.Int("min-sites", 1,
+		"Leave out groups with fewer sites than this, counting them in other")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan aggregate [-by=server|tld] results\n")
+		return 2
+	}
+	group, ok := aggregations[*by]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "-by must be server or tld\n")
+		return 2
+	}
+
+	sites, order, err := readResults(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %s\n", err)
+		return 1
+	}
+
+	rows := make(map[string]*aggregateRow)
+	total := &aggregateRow{group: "total", verdicts: make(map[string]int)}
+	for _, k := range order {
+		s := sites[k]
+		g := group(s)
+		if rows[g] == nil {
+			rows[g] = &aggregateRow{group: g, verdicts: make(map[string]int)}
+		}
+		for _, r := range []*aggregateRow{rows[g], total} {
+			r.sites++
+			r.verdicts[s.verdict()]++
+		}
+	}
+
+	other := &aggregateRow{group: "other", verdicts: make(map[string]int)}
+	var list []*aggregateRow
+	for _, r := range rows {
+		if r.sites >= *minSites {
+			list = append(list, r)
+			continue
+		}
+		other.sites += r.sites
+		for v, n := range r.verdicts {
+			other.verdicts[v] += n
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].sites != list[j].sites {
+			return list[i].sites > list[j].sites
+		}
+		return list[i].group < list[j].group
+	})
+	if other.sites > 0 {
+		list = append(list, other)
+	}
+
+	fmt.Printf("%-30s %8s %8s %9s %9s %9s\n", strings.ToUpper(*by), "SITES",
+		"TESTED", "ENCODING", "SIZE", "BLOCKED")
+	for _, r := range append(list, total) {
+		fmt.Printf("%-30s %8d %8d %8.1f%% %8.1f%% %8.1f%%\n", r.group,
+			r.sites, r.tested(), r.percent("encoding"), r.percent("size"),
+			r.percent("blocked"))
+	}
+
+	return 0
+}
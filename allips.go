@@ -0,0 +1,55 @@
+package viascan
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// originIPv6 returns the IPv6 addresses of name, or none if they could
+// not be looked up
+func (cfg *config) originIPv6(name string) []net.IP {
+	ips, _, _ := cfg.resolver.resolver.lookupAddrs(name, dns.TypeAAAA)
+	return ips
+}
+
+// enqueueIPs queues up a new site for each of the other addresses of
+// the origin of s found with -all-ips. Each is tested as if its address
+// had been given on the input line so that there is a result for each.
+func (f *follower) enqueueIPs(s *site) {
+	for _, ip := range s.otherIPs {
+		n := site{cfg: s.cfg, host: s.host, origin: s.origin, ip: ip,
+			split: true, scheme: s.scheme, encoding: s.encoding, path: s.path,
+			viaValue: s.viaValue, probe: s.probe, proto: s.proto,
+			depth: s.depth, followedFrom: s.followedFrom, line: s.line,
+			resolvedIPs: s.resolvedIPs, cnames: s.cnames}
+		if !f.track(&n) {
+			continue
+		}
+
+		n.line.add()
+		f.pending.Add(1)
+		atomic.AddInt64(&f.counts.queued, 1)
+		go func() {
+			select {
+			case f.work <- &n:
+			case <-f.abort:
+				f.pending.Done()
+			}
+		}()
+	}
+}
+
+// family returns ipv4 or ipv6 for the address the site was tested at,
+// or nothing if it is not known
+func (s *site) family() string {
+	ip := net.ParseIP(s.addr)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
@@ -0,0 +1,20 @@
+package viascan
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+)
+
+// isReset returns true if err is the origin resetting the connection
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// forbidden returns true if a request with Via was refused with 403
+// when the same request without Via was not, i.e. the origin blocks
+// requests that come through a proxy
+func (s *site) forbidden() bool {
+	return s.viaStatus == http.StatusForbidden &&
+		s.noViaStatus != http.StatusForbidden
+}
@@ -0,0 +1,154 @@
+package viascan
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// backoff spaces out the requests to origins that have answered 429
+// or 503 (see -max-backoff). Each such answer doubles the delay between
+// requests to that origin, or sets it to the Retry-After the origin
+// asked for if that is longer, up to max. The delay halves for every
+// backoffDecay that passes without another one so that origins that
+// have recovered are soon tested at full speed again. The delay never
+// falls below the Crawl-delay an origin's robots.txt asks for (see
+// -respect-robots).
+type backoff struct {
+	sync.Mutex
+	max     time.Duration
+	origins map[string]*originDelay
+}
+
+// originDelay is the delay between requests to one origin
+type originDelay struct {
+	delay   time.Duration // Delay when last increased
+	updated time.Time     // When the delay was last increased
+	next    time.Time     // Earliest time of the next request
+	floor   time.Duration // Least delay (Crawl-delay)
+}
+
+// backoffDecay is how long it takes for an origin's delay to halve
+const backoffDecay = 30 * time.Second
+
+// backoffStep is the delay after the first 429 or 503 from an origin
+// that did not say how long to wait
+const backoffStep = time.Second
+
+func newBackoff(max time.Duration) *backoff {
+	return &backoff{max: max, origins: make(map[string]*originDelay)}
+}
+
+// current returns the delay for d decayed to now but no less than its
+// floor
+func (d *originDelay) current(now time.Time) time.Duration {
+	if delay := d.decayed(now); delay > d.floor {
+		return delay
+	}
+	return d.floor
+}
+
+// decayed returns the delay for d decayed to now
+func (d *originDelay) decayed(now time.Time) time.Duration {
+	halvings := now.Sub(d.updated) / backoffDecay
+	if halvings > 30 {
+		return 0
+	}
+	delay := d.delay >> uint(halvings)
+	if delay < time.Millisecond {
+		return 0
+	}
+	return delay
+}
+
+// wait blocks until a request may be sent to origin
+func (b *backoff) wait(origin string) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	d := b.origins[origin]
+	if d == nil {
+		b.Unlock()
+		return
+	}
+	now := time.Now()
+	start := d.next
+	if start.Before(now) {
+		start = now
+	}
+	d.next = start.Add(d.current(start))
+	b.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+// record increases the delay for origin if resp asked it to slow down
+func (b *backoff) record(origin string, resp *http.Response) {
+	if b == nil || (resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable) {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	d := b.origins[origin]
+	if d == nil {
+		d = &originDelay{}
+		b.origins[origin] = d
+	}
+	delay := 2 * d.decayed(now)
+	if delay < backoffStep {
+		delay = backoffStep
+	}
+	if after := retryAfter(resp, now); after > delay {
+		delay = after
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	d.delay, d.updated = delay, now
+	if next := now.Add(delay); next.After(d.next) {
+		d.next = next
+	}
+}
+
+// crawlDelay makes delay the least delay between requests to origin
+// unless a longer one has already been
+func (b *backoff) crawlDelay(origin string, delay time.Duration) {
+	if b == nil || delay <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	d := b.origins[origin]
+	if d == nil {
+		d = &originDelay{}
+		b.origins[origin] = d
+	}
+	if delay > d.floor {
+		d.floor = delay
+	}
+}
+
+// retryAfter returns how long the Retry-After header in resp asks
+// clients to wait, or 0 if there is none
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+)
+
+// rejected returns true if a request failed or got an error status
+func rejected(r response, err error) bool {
+	return err != nil || r.status >= 400
+}
+
+// testCDNLoop repeats the requests with and without Via adding a
+// CDN-Loop header and reports whether origins (or CDNs in front of
+// them) reject them as a loop: none, via-only, no-via-only or always.
+// A request only counts as rejected if the same request without
+// CDN-Loop was not.
+func (s *site) testCDNLoop(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request, noVia, via response) string {
+	noViaLoop := req.Clone(req.Context())
+	noViaLoop.Header.Del("Via")
+	noViaLoop.Header.Set("CDN-Loop", s.cfg.cdnLoop)
+	r, err := s.fetch(l, client, transport, noViaLoop)
+	noViaRejected := rejected(r, err) && !rejected(noVia, nil)
+
+	viaLoop := req.Clone(req.Context())
+	viaLoop.Header.Set("CDN-Loop", s.cfg.cdnLoop)
+	r, err = s.fetch(l, client, transport, viaLoop)
+	viaRejected := rejected(r, err) && !rejected(via, nil)
+
+	switch {
+	case noViaRejected && viaRejected:
+		return "always"
+	case viaRejected:
+		return "via-only"
+	case noViaRejected:
+		return "no-via-only"
+	}
+	return "none"
+}
@@ -0,0 +1,135 @@
+package viascan
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkpoint records the input lines whose sites have all been tested
+// and written out (see -checkpoint) so that an interrupted scan can be
+// run again on the same input and carry on where it stopped. The file
+// holds one completed input line per line and is replaced with a new
+// copy at most every checkpointEvery as lines complete, so that it is
+// never left half written.
+type checkpoint struct {
+	sync.Mutex
+
+	file    string
+	done    map[string]bool // Lines completed in previous runs
+	lines   []string        // Every line completed, in this run or before
+	written time.Time       // When file was last replaced
+	err     error           // First error replacing file
+}
+
+// checkpointEvery is how often the checkpoint file is replaced
+const checkpointEvery = time.Second
+
+// inputLine is an input line whose sites are being tested. pending
+// counts its sites not yet written out plus one held until all of them
+// have been queued.
+type inputLine struct {
+	text    string
+	pending int32
+}
+
+// openCheckpoint reads the lines completed by previous runs from file,
+// which need not exist, and opens it to record more
+func openCheckpoint(file string) (*checkpoint, error) {
+	c := &checkpoint{file: file, done: make(map[string]bool)}
+
+	if f, err := os.Open(file); err == nil {
+		scan := bufio.NewScanner(f)
+		for scan.Scan() {
+			c.done[scan.Text()] = true
+			c.lines = append(c.lines, scan.Text())
+		}
+		f.Close()
+		if scan.Err() != nil {
+			return nil, scan.Err()
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// save replaces the checkpoint file with one holding c.lines by way of
+// a temporary file, so that a failed write leaves the old one in place
+func (c *checkpoint) save() error {
+	c.written = time.Now()
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), ".viascan-checkpoint")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, line := range c.lines {
+		w.WriteString(line + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// start returns the inputLine to attach to the sites of text, or nil
+// if it was completed by a previous run and should be skipped
+func (c *checkpoint) start(text string) (*inputLine, bool) {
+	if c == nil {
+		return nil, true
+	}
+	if c.done[text] {
+		return nil, false
+	}
+	return &inputLine{text: text, pending: 1}, true
+}
+
+// add counts another site of line
+func (l *inputLine) add() {
+	if l != nil {
+		atomic.AddInt32(&l.pending, 1)
+	}
+}
+
+// release marks a site of line (or the hold on it while its sites are
+// queued) as done and records the line once none are left
+func (c *checkpoint) release(l *inputLine) {
+	if c == nil || l == nil || atomic.AddInt32(&l.pending, -1) != 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.lines = append(c.lines, l.text)
+	if c.err == nil && time.Since(c.written) >= checkpointEvery {
+		if c.err = c.save(); c.err != nil {
+			warnf("Failed to write -checkpoint %s: %s\n", c.file, c.err)
+		}
+	}
+}
+
+// close writes the checkpoint file for the last time, even if an
+// earlier write failed
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return c.save()
+}
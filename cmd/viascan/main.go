@@ -0,0 +1,14 @@
+// viascan tests web servers to see if they change their compression
+// behaviour when the HTTP Via header is added. See the README for how
+// to use it; the work is done by the viascan package.
+package main
+
+import (
+	"os"
+
+	"github.com/jgrahamc/viascan"
+)
+
+func main() {
+	os.Exit(viascan.Main(os.Args[1:]))
+}
@@ -0,0 +1,45 @@
+package viascan
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// cnameChain returns the names the CNAME records in the answer to a
+// lookup lead through in order, starting from the name looked up, or
+// none if there are none
+func cnameChain(msg *dns.Msg) []string {
+	if msg == nil || len(msg.Question) == 0 {
+		return nil
+	}
+
+	var chain []string
+	name := msg.Question[0].Name
+	for range msg.Answer {
+		next := ""
+		for _, rr := range msg.Answer {
+			if c, ok := rr.(*dns.CNAME); ok &&
+				strings.EqualFold(c.Hdr.Name, name) {
+				next = c.Target
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, strings.TrimSuffix(next, "."))
+		name = next
+	}
+	return chain
+}
+
+// joinIPs returns ips separated by /
+func joinIPs(ips []net.IP) string {
+	list := make([]string, len(ips))
+	for i, ip := range ips {
+		list[i] = ip.String()
+	}
+	return strings.Join(list, "/")
+}
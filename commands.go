@@ -0,0 +1,65 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a viascan subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands. Running viascan with only flags (or
+// nothing) is the same as viascan scan.
+var commands = []command{
+	{"scan", "Test the sites read from stdin (the default)", scanCommand},
+	{"serve", "Test the sites POSTed to an HTTP service", serveCommand},
+	{"diff", "Compare the verdicts in two results files", diffCommand},
+	{"report", "Summarize the verdicts in a results file", reportCommand},
+	{"aggregate", "Compare how groups of sites (e.g. by Server) behave with Via", aggregateCommand},
+	{"explain", "Test one site step by step to see how its result came about", explainCommand},
+	{"selftest", "Check viascan against a built-in test server", selftestCommand},
+	{"pair", "Build input lines from lists of hosts and origins", pairCommand},
+	{"portscan", "Build input lines from masscan or zmap output", portscanCommand},
+	{"verify", "Check the trailers in a results file", verifyCommand},
+	{"scope", "Create keys and sign -scope files", scopeCommand},
+	{"history", "Record runs and report changes over time", historyCommand},
+	{"resolvers", "Benchmark resolvers to choose one for -resolver", resolversCommand},
+}
+
+// usage lists the subcommands on stderr
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: viascan [command] [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun viascan <command> -h for the command's flags.\n")
+}
+
+// Main runs the viascan command with args, which do not include the
+// program name, and returns its exit status. Running it with only
+// flags (or nothing) is the same as running the scan command.
+func Main(args []string) int {
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		return scanCommand(args)
+	}
+
+	name := args[0]
+	if name == "help" {
+		usage()
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", name)
+	usage()
+	return 2
+}
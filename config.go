@@ -0,0 +1,114 @@
+package viascan
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultVia is the value of the Via header sent unless -via is given
+const defaultVia = "viascan 1.0"
+
+// config is the settings of a scan, from the flags of the scan and
+// serve commands or a Scanner, and the state its sites share. Each
+// pipeline has its own so that more than one Scanner can run at once.
+type config struct {
+	resolver  *sharedResolver // Looks up origins (see -resolver)
+	viaHeader string          // Value of the Via header sent (see -via)
+	method    string          // HTTP method of the requests with and without Via (see -method)
+	userAgent string          // User-Agent sent with every request (-user-agent), or empty for Go's own
+
+	// Port set by -port to connect to origins that do not give one, or
+	// empty for the scheme's
+	defaultPort string
+
+	// Encodings that -br and -zstd add to the Accept-Encoding sent,
+	// after gzip and deflate
+	extraEncodings []string
+
+	clientFingerprint string   // How connections are made (see -client-fingerprint)
+	proxyURL          *url.URL // -proxy that connections are tunneled through, or nil
+	ipFamily          int      // 4 or 6 if -4 or -6 is given, otherwise 0
+	allIPs            bool     // Whether every address of an origin is tested (-all-ips)
+	sockOpts          socketOptions
+	timeouts          timeoutSettings
+	maxRedirects      int // Redirects followed for each request (see -follow-redirects)
+
+	// Limits on decompressing bodies so that a hostile origin can't make
+	// viascan allocate huge amounts of memory (see -max-decompressed-mb
+	// and -max-expansion-ratio)
+	maxDecompressed int64
+	maxRatio        int64
+
+	// Bytes of each body that are read when -sample-bytes is set (0
+	// reads whole bodies)
+	sampleBytes int64
+
+	// Times an interrupted body is resumed with a Range request rather
+	// than being cut short (-resume)
+	resumes int
+
+	// Times a request that failed in a way that may not happen again is
+	// repeated (-retries), waiting retryBackoff before the first repeat
+	// and twice as long before each one after it (-retry-backoff)
+	retries      int
+	retryBackoff time.Duration
+
+	// The optional probes, set by their flags (see -no-cache, -ae-order,
+	// -ae-matrix, -cdn-loop, -forwarded, -ua-compare, -dictionary,
+	// -websocket, -echo-path, -trigger-search, -minimal-retry and
+	// -pmtu-check) and limited to some sites by probeRules
+	noCache        string
+	aeOrder        bool
+	aeMatrix       bool
+	cdnLoop        string
+	forwarded      bool
+	uaCompare      bool
+	dictionary     bool
+	websocket      bool
+	echoPath       string
+	triggerSearch  bool
+	minimalRetry   bool
+	minimalHeaders []string // Headers other than Host sent by -minimal-retry
+	pmtuCheck      bool
+	probeRules     ruleSet
+
+	timings bool   // Whether to report when each request was made (-timings)
+	splitBy string // Set by -output-split-by
+
+	// Directory to which -dump-dir writes a file for each site (empty
+	// if sites are not dumped) and the verdicts of the sites that are
+	// written (all sites if empty)
+	dumpDir      string
+	dumpVerdicts map[string]bool
+
+	// An http.Transport for each protocol (see newTransport) shared by
+	// all sites when -pool is set so that connections to an origin are
+	// reused across sites, otherwise each site gets its own
+	pools map[string]http.RoundTripper
+
+	hooks       Hooks        // Set from Scanner.Hooks; the viascan command sets none
+	scope       *scopeList   // nil if there is no restriction on what can be scanned
+	myShard     shard        // The zero shard (n == 0) when all sites are tested
+	dead        *deadCache   // nil if there is no -dead-cache
+	checkpoints *checkpoint  // nil if there is no -checkpoint
+	lastRuns    *sql.DB      // -history-db to annotate sites with, or nil
+	backoffs    *backoff     // Set when -max-backoff or -respect-robots is given
+	rateLimits  *rateLimit   // Set when -rate or -per-host-rate is given
+	robots      *robotsCache // Set when -respect-robots is given
+	used        *usageCounts // What the sites have used, reported at the end
+}
+
+// newConfig returns the settings of the scan command's flags at their
+// defaults, other than the resolver
+func newConfig() *config {
+	return &config{viaHeader: defaultVia, method: http.MethodGet,
+		clientFingerprint: "system", maxRedirects: 10,
+		maxDecompressed: 64 << 20, maxRatio: 200,
+		minimalHeaders: []string{"Accept-Encoding"},
+		sockOpts:       socketOptions{noDelay: true, keepAlive: 15 * time.Second},
+		timeouts: timeoutSettings{connect: 10 * time.Second,
+			tls: 10 * time.Second, responseHeader: 30 * time.Second},
+		used: &usageCounts{requests: make(map[string]int64)}}
+}
@@ -0,0 +1,119 @@
+package viascan
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadCache remembers origins that could not be resolved or reached
+// in previous runs (see -dead-cache) so that daily re-scans of mostly
+// dead lists can skip them with -skip-known-dead.
+type deadCache struct {
+	sync.Mutex
+
+	file string               // File the cache is kept in
+	ttl  time.Duration        // How long an origin is considered dead
+	skip bool                 // Whether to skip origins known to be dead
+	dead map[string]time.Time // When each dead origin was last found dead
+}
+
+// loadDeadCache reads the cache from file. A missing file is an empty
+// cache. Each line is an origin and the Unix time it was found dead.
+func loadDeadCache(file string, ttl time.Duration, skip bool) (*deadCache, error) {
+	d := &deadCache{file: file, ttl: ttl, skip: skip,
+		dead: make(map[string]time.Time)}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		parts := strings.Split(scan.Text(), ",")
+		if len(parts) != 2 {
+			continue
+		}
+		t, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		when := time.Unix(t, 0)
+		if time.Since(when) < ttl {
+			d.dead[parts[0]] = when
+		}
+	}
+
+	return d, scan.Err()
+}
+
+// known returns true if origin should be skipped because it was found
+// dead within the TTL
+func (d *deadCache) known(origin string) bool {
+	if d == nil || !d.skip {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	when, ok := d.dead[origin]
+	return ok && time.Since(when) < d.ttl
+}
+
+// record updates the cache with the outcome of testing s
+func (d *deadCache) record(s *site) {
+	if d == nil || !s.resolves.ran {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if !s.resolves.yesno || (s.noVia.ran && !s.noVia.yesno) {
+		d.dead[s.origin] = time.Now()
+	} else if s.noVia.yesno {
+		delete(d.dead, s.origin)
+	}
+}
+
+// save writes the cache back to its file, replacing it atomically
+func (d *deadCache) save() error {
+	if d == nil {
+		return nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(d.file), ".viascan-dead")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for origin, when := range d.dead {
+		if time.Since(when) < d.ttl {
+			fmt.Fprintf(w, "%s,%d\n", origin, when.Unix())
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), d.file)
+}
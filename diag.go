@@ -0,0 +1,38 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+)
+
+// Levels of -quiet and -verbose. Only results are ever written to
+// stdout so that it can be piped; everything else goes to stderr at
+// one of these levels.
+const (
+	quiet   = iota // Only errors that stop the scan
+	normal         // Also problems that do not, such as a bad input line
+	verbose        // Also everything written to the -log file
+)
+
+// verbosity is the level set by -quiet or -verbose
+var verbosity = normal
+
+// errorf reports a problem that stops the scan
+func errorf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// warnf reports a problem that the scan carries on after unless -quiet
+// was given
+func warnf(format string, a ...interface{}) {
+	if verbosity >= normal {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
+
+// infof reports what the scan is doing if -verbose was given
+func infof(format string, a ...interface{}) {
+	if verbosity >= verbose {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
@@ -0,0 +1,91 @@
+package viascan
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+// dictionaryID finds the id parameter of a Use-As-Dictionary header
+var dictionaryID = regexp.MustCompile(`(?:^|[;,\s])id=("(?:[^"\\]|\\.)*")`)
+
+// offeredDictionary records a response's offer to be used as a
+// dictionary: the SHA-256 of the decoded body, which is how clients
+// name the dictionary, and the id the origin gave it. hash is empty if
+// there was no offer and unknown if the body could not be decoded.
+type offeredDictionary struct {
+	hash string
+	id   string
+}
+
+// offer returns the dictionary offered by a response with header h
+// and body as received
+func (cfg *config) offer(h http.Header, body []byte,
+	encoding string) offeredDictionary {
+	use := h.Get("Use-As-Dictionary")
+	if use == "" {
+		return offeredDictionary{}
+	}
+
+	var d offeredDictionary
+	if m := dictionaryID.FindStringSubmatch(use); m != nil {
+		d.id = m[1]
+	}
+	r, ok := cfg.decode(body, encoding)
+	if !ok {
+		d.hash = "unknown"
+		return d
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		d.hash = "unknown"
+		return d
+	}
+	sum := sha256.Sum256(decoded)
+	d.hash = ":" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	return d
+}
+
+// testDictionary repeats the requests with and without Via telling
+// the origin that the body each got is available as a dictionary and
+// accepting the dictionary codings dcb and dcz. It reports the
+// Content-Encoding of each response (dcb or dcz if the dictionary was
+// used), none if the original response did not offer a dictionary,
+// unknown if its body could not be decoded to find the dictionary's
+// hash, or failed if the request failed.
+func (s *site) testDictionary(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request,
+	noVia, via response) (string, string) {
+	try := func(with bool, r response) string {
+		switch r.dictionary.hash {
+		case "":
+			return "none"
+		case "unknown":
+			return "unknown"
+		}
+
+		d := req.Clone(req.Context())
+		if !with {
+			d.Header.Del("Via")
+		}
+		d.Header.Set("Accept-Encoding", req.Header.Get("Accept-Encoding")+
+			",dcb,dcz")
+		d.Header.Set("Available-Dictionary", r.dictionary.hash)
+		if r.dictionary.id != "" {
+			d.Header.Set("Dictionary-ID", r.dictionary.id)
+		}
+		got, err := s.fetch(l, client, transport, d)
+		if err != nil {
+			return "failed"
+		}
+		if got.encoding == "" {
+			return "identity"
+		}
+		return got.encoding
+	}
+
+	return try(false, noVia), try(true, via)
+}
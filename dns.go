@@ -0,0 +1,224 @@
+package viascan
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsResolver looks up names by sending queries directly to a DNS
+// server rather than using the system's resolver. Queries use EDNS0
+// and are retried over TCP if the UDP answer was truncated, or are
+// sent over HTTPS if the server is a DNS-over-HTTPS URL or over TLS if
+// it is a DNS-over-TLS server (see newDoTResolver).
+type dnsResolver struct {
+	server  string        // Address of the DNS server (host:port) or DoH URL
+	timeout time.Duration // Time to wait for each query
+	retries int           // Number of times to retry a query that timed out
+	family  int           // 4 or 6 to look up only A or AAAA records, otherwise 0
+
+	tls      *tls.Config  // Set if server is a DNS-over-TLS server
+	doh      *http.Client // Sends DNS-over-HTTPS queries (see dialDoH)
+	fallback *dnsResolver // Resolver to send queries that failed to
+
+	// Makes sure that queries falling back are only reported once
+	fallbackOnce sync.Once
+}
+
+// ednsSize is the UDP payload size advertised with EDNS0
+const ednsSize = 1232
+
+func newDNSResolver(server string, timeout time.Duration,
+	retries int) *dnsResolver {
+	if isDoH(server) || server == systemResolver {
+		return &dnsResolver{server: server, timeout: timeout, retries: retries}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &dnsResolver{server: server, timeout: timeout, retries: retries}
+}
+
+// newDNSResolvers returns a resolver for the first of a
+// comma-separated list of servers that fails over to the others in
+// turn
+func newDNSResolvers(servers string, timeout time.Duration,
+	retries int) *dnsResolver {
+	var first, last *dnsResolver
+	for _, server := range strings.Split(servers, ",") {
+		r := newDNSResolver(strings.TrimSpace(server), timeout, retries)
+		if first == nil {
+			first = r
+		} else {
+			last.fallback = r
+		}
+		last = r
+	}
+	return first
+}
+
+// setFamily makes the resolver, and those it fails over to, look up
+// only the addresses of family (4 or 6, or 0 for both)
+func (r *dnsResolver) setFamily(family int) {
+	for ; r != nil; r = r.fallback {
+		r.family = family
+	}
+}
+
+// exchange sends a query to the server, and to the fallback resolver
+// if there is one and the server did not answer or answered SERVFAIL
+func (r *dnsResolver) exchange(m *dns.Msg) (*dns.Msg, error) {
+	if r.server == systemResolver {
+		return r.exchangeSystem(m)
+	}
+
+	in, err := r.send(m)
+	if err == nil && in.Rcode == dns.RcodeServerFailure && r.fallback != nil {
+		err = errors.New("SERVFAIL")
+	}
+	if err != nil && r.fallback != nil {
+		r.fallbackOnce.Do(func() {
+			warnf("DNS query to %s failed (%s), using %s\n", r.server, err,
+				r.fallback.server)
+		})
+		return r.fallback.exchange(m)
+	}
+	return in, err
+}
+
+// send sends a query over UDP (and TCP if the answer was truncated),
+// HTTPS or TLS, retrying if the server does not answer in time
+func (r *dnsResolver) send(m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: r.timeout, UDPSize: ednsSize}
+	if r.tls != nil {
+		c = &dns.Client{Net: "tcp-tls", Timeout: r.timeout, TLSConfig: r.tls}
+	}
+
+	var err error
+	for try := 0; try <= r.retries; try++ {
+		var in *dns.Msg
+		if isDoH(r.server) {
+			in, err = r.exchangeDoH(m)
+		} else {
+			in, _, err = c.Exchange(m, r.server)
+		}
+		if err == nil && in.Truncated && c.Net == "udp" {
+			tcp := &dns.Client{Net: "tcp", Timeout: r.timeout}
+			in, _, err = tcp.Exchange(m, r.server)
+		}
+		if err == nil {
+			return in, nil
+		}
+
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+// lookup sends a query for name of type t and returns the answer. The
+// answer is also returned with the error if its rcode was a failure.
+func (r *dnsResolver) lookup(name string, t uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), t)
+	m.SetEdns0(ednsSize, false)
+
+	in, err := r.exchange(m)
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return in, fmt.Errorf("%s looking up %s", dns.RcodeToString[in.Rcode],
+			name)
+	}
+
+	return in, nil
+}
+
+// LookupHost returns the addresses for name of the resolver's family,
+// following any CNAME records in the answer
+func (r *dnsResolver) LookupHost(name string) ([]net.IP, error) {
+	ips, _, err := r.lookupHost(name)
+	return ips, err
+}
+
+// lookupHost is LookupHost also returning the DNS response the
+// addresses came from (nil if there was none)
+func (r *dnsResolver) lookupHost(name string) ([]net.IP, *dns.Msg, error) {
+	switch r.family {
+	case 4:
+		return r.lookupAddrs(name, dns.TypeA)
+	case 6:
+		return r.lookupAddrs(name, dns.TypeAAAA)
+	}
+
+	ips, in, err := r.lookupAddrs(name, dns.TypeA)
+	if err != nil && in != nil && in.Rcode == dns.RcodeSuccess {
+		return r.lookupAddrs(name, dns.TypeAAAA)
+	}
+	return ips, in, err
+}
+
+// maxCNAMEs is the number of times lookupAddrs asks for the target of
+// a CNAME that came without its addresses
+const maxCNAMEs = 8
+
+// lookupAddrs returns the addresses of name from a query of type t (A
+// or AAAA) and the response they came from
+func (r *dnsResolver) lookupAddrs(name string, t uint16) ([]net.IP,
+	*dns.Msg, error) {
+	in, err := r.lookup(name, t)
+	if err != nil {
+		return nil, in, err
+	}
+
+	// A server that answers with only the CNAME records of a name is
+	// asked about the name they lead to in turn
+
+	ips := addrs(in)
+	for i := 0; i < maxCNAMEs && len(ips) == 0; i++ {
+		chain := cnameChain(in)
+		if len(chain) == 0 {
+			break
+		}
+		next, err := r.lookup(chain[len(chain)-1], t)
+		if err != nil {
+			return nil, in, err
+		}
+		if len(next.Answer) == 0 {
+			break
+		}
+		in.Answer = append(in.Answer, next.Answer...)
+		ips = addrs(in)
+	}
+	if len(ips) == 0 {
+		return nil, in, fmt.Errorf("no addresses for %s",
+			strings.TrimSuffix(name, "."))
+	}
+
+	return ips, in, nil
+}
+
+// addrs returns the addresses in the answer of in
+func addrs(in *dns.Msg) []net.IP {
+	var ips []net.IP
+	for _, rr := range in.Answer {
+		switch a := rr.(type) {
+		case *dns.A:
+			ips = append(ips, a.A)
+		case *dns.AAAA:
+			ips = append(ips, a.AAAA)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,233 @@
+package viascan
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testServer is a DNS server on localhost answering over UDP and TCP
+// on the same port with handle, which is given the number of each query
+// from 1
+type testServer struct {
+	sync.Mutex
+	addr    string
+	queries []*dns.Msg // Every query received, in order
+	nets    []string   // udp or tcp for each of queries
+}
+
+func newTestServer(t *testing.T,
+	handle func(w dns.ResponseWriter, q *dns.Msg, n int)) *testServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("cannot listen on TCP and UDP on the same port: %s", err)
+	}
+
+	ts := &testServer{addr: pc.LocalAddr().String()}
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		ts.Lock()
+		ts.queries = append(ts.queries, q)
+		ts.nets = append(ts.nets, w.LocalAddr().Network())
+		n := len(ts.queries)
+		ts.Unlock()
+		handle(w, q, n)
+	})
+	udp := &dns.Server{PacketConn: pc, Handler: h}
+	tcp := &dns.Server{Listener: l, Handler: h}
+	for _, s := range []*dns.Server{udp, tcp} {
+		started := make(chan struct{})
+		s.NotifyStartedFunc = func() { close(started) }
+		go s.ActivateAndServe()
+		<-started
+	}
+	t.Cleanup(func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	})
+	return ts
+}
+
+// sent returns the queries received and the network of each since the
+// last reset
+func (ts *testServer) sent() ([]*dns.Msg, []string) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	return ts.queries, ts.nets
+}
+
+// reset forgets the queries received so far
+func (ts *testServer) reset() {
+	ts.Lock()
+	ts.queries, ts.nets = nil, nil
+	ts.Unlock()
+}
+
+// answer replies to q with rrs
+func answer(w dns.ResponseWriter, q *dns.Msg, rrs ...string) {
+	m := new(dns.Msg)
+	m.SetReply(q)
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	w.WriteMsg(m)
+}
+
+func TestResolverRetries(t *testing.T) {
+	// The first query goes unanswered
+
+	ts := newTestServer(t, func(w dns.ResponseWriter, q *dns.Msg, n int) {
+		if n > 1 {
+			answer(w, q, "example.com. 60 IN A 192.0.2.1")
+		}
+	})
+
+	r := newDNSResolver(ts.addr, 100*time.Millisecond, 0)
+	if _, err := r.LookupHost("example.com"); err == nil {
+		t.Errorf("lookup with no retries succeeded")
+	}
+
+	ts.reset()
+	r = newDNSResolver(ts.addr, 100*time.Millisecond, 1)
+	ips, err := r.LookupHost("example.com")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("lookup with a retry = %v, %v", ips, err)
+	}
+	if q, _ := ts.sent(); len(q) != 2 {
+		t.Errorf("sent %d queries, want 2", len(q))
+	}
+}
+
+func TestResolverTruncated(t *testing.T) {
+	// Over UDP the answer is truncated, over TCP it is whole
+
+	ts := newTestServer(t, func(w dns.ResponseWriter, q *dns.Msg, n int) {
+		if w.LocalAddr().Network() == "udp" {
+			m := new(dns.Msg)
+			m.SetReply(q)
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		answer(w, q, "example.com. 60 IN A 192.0.2.1",
+			"example.com. 60 IN A 192.0.2.2")
+	})
+
+	ips, err := newDNSResolver(ts.addr, time.Second, 0).LookupHost("example.com")
+	if err != nil || len(ips) != 2 {
+		t.Errorf("lookup = %v, %v", ips, err)
+	}
+	_, nets := ts.sent()
+	if want := []string{"udp", "tcp"}; !reflect.DeepEqual(nets, want) {
+		t.Errorf("queries sent over %v, want %v", nets, want)
+	}
+}
+
+func TestResolverEDNS0(t *testing.T) {
+	ts := newTestServer(t, func(w dns.ResponseWriter, q *dns.Msg, n int) {
+		answer(w, q, "example.com. 60 IN A 192.0.2.1")
+	})
+
+	if _, err := newDNSResolver(ts.addr, time.Second,
+		0).LookupHost("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	q, _ := ts.sent()
+	opt := q[0].IsEdns0()
+	if opt == nil || opt.UDPSize() != ednsSize {
+		t.Errorf("query has OPT %v, want a UDP size of %d", opt, ednsSize)
+	}
+}
+
+func TestResolverCNAME(t *testing.T) {
+	// www.example.com is answered with its whole chain, cdn.example.com
+	// with only its CNAME
+
+	ts := newTestServer(t, func(w dns.ResponseWriter, q *dns.Msg, n int) {
+		switch q.Question[0].Name {
+		case "www.example.com.":
+			answer(w, q, "www.example.com. 60 IN CNAME a.example.net.",
+				"a.example.net. 60 IN CNAME b.example.net.",
+				"b.example.net. 60 IN A 192.0.2.1")
+		case "cdn.example.com.":
+			answer(w, q, "cdn.example.com. 60 IN CNAME a.example.net.")
+		case "a.example.net.":
+			answer(w, q, "a.example.net. 60 IN CNAME b.example.net.")
+		case "b.example.net.":
+			answer(w, q, "b.example.net. 60 IN A 192.0.2.1")
+		case "loop.example.com.":
+			answer(w, q, "loop.example.com. 60 IN CNAME loop.example.com.")
+		default:
+			answer(w, q)
+		}
+	})
+
+	r := newDNSResolver(ts.addr, time.Second, 0)
+	r.family = 4
+	want := []string{"a.example.net", "b.example.net"}
+	for _, name := range []string{"www.example.com", "cdn.example.com"} {
+		ips, msg, err := r.lookupHost(name)
+		if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+			t.Errorf("%s: lookup = %v, %v", name, ips, err)
+			continue
+		}
+		if chain := cnameChain(msg); !reflect.DeepEqual(chain, want) {
+			t.Errorf("%s: CNAME chain %v, want %v", name, chain, want)
+		}
+	}
+
+	ts.reset()
+	if ips, _, err := r.lookupHost("loop.example.com"); err == nil {
+		t.Errorf("lookup of a CNAME loop = %v", ips)
+	}
+	if q, _ := ts.sent(); len(q) > maxCNAMEs+1 {
+		t.Errorf("sent %d queries for a CNAME loop", len(q))
+	}
+}
+
+// A name that exists is answered NOERROR by the system resolver for
+// both A and AAAA even though it only has addresses of one of them
+func TestSystemResolverNoData(t *testing.T) {
+	r := newDNSResolver(systemResolver, time.Second, 0)
+	answers := 0
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		m := new(dns.Msg)
+		m.SetQuestion("localhost.", qtype)
+		in, err := r.exchange(m)
+		if err != nil {
+			t.Fatalf("%s: %s", dns.TypeToString[qtype], err)
+		}
+		if in.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: got %s, want NOERROR", dns.TypeToString[qtype],
+				dns.RcodeToString[in.Rcode])
+		}
+		answers += len(in.Answer)
+	}
+	if answers == 0 {
+		t.Errorf("localhost has no addresses")
+	}
+}
+
+func TestSetFamily(t *testing.T) {
+	r := newDoTResolver("192.0.2.53", time.Second, 0)
+	r.fallback = newDNSResolvers("192.0.2.1,192.0.2.2", time.Second, 0)
+	r.setFamily(6)
+	for n := r; n != nil; n = n.fallback {
+		if n.family != 6 {
+			t.Errorf("resolver %s has family %d, want 6", n.server, n.family)
+		}
+	}
+}
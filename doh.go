@@ -0,0 +1,86 @@
+package viascan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dohType is the media type of DNS messages sent over HTTPS (RFC 8484)
+const dohType = "application/dns-message"
+
+// dohClient sends DNS-over-HTTPS queries unless dialDoH has given the
+// resolver its own
+var dohClient = &http.Client{}
+
+// isDoH returns whether server is a DNS-over-HTTPS URL (e.g.
+// https://1.1.1.1/dns-query) rather than a DNS server address
+func isDoH(server string) bool {
+	return strings.HasPrefix(strings.ToLower(server), "https://")
+}
+
+// dialDoH makes the resolver, and those it fails over to, connect to
+// DNS-over-HTTPS servers with dial so that queries go through the
+// -proxy. The client is otherwise separate from those used to test
+// origins so that none of their settings apply.
+func (r *dnsResolver) dialDoH(dial func(ctx context.Context, network,
+	addr string) (net.Conn, error)) {
+	client := &http.Client{Transport: &http.Transport{DialContext: dial,
+		ForceAttemptHTTP2: true}}
+	for ; r != nil; r = r.fallback {
+		r.doh = client
+	}
+}
+
+// exchangeDoH POSTs a query to the DNS-over-HTTPS server. The ID is
+// sent as 0, as RFC 8484 asks so that answers can be cached, and put
+// back in the answer.
+func (r *dnsResolver) exchangeDoH(m *dns.Msg) (*dns.Msg, error) {
+	q := m.Copy()
+	q.Id = 0
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", r.server,
+		bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohType)
+	req.Header.Set("Accept", dohType)
+
+	client := r.doh
+	if client == nil {
+		client = dohClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server returned %s",
+			resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	in.Id = m.Id
+	return in, nil
+}
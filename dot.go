@@ -0,0 +1,22 @@
+package viascan
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// newDoTResolver returns a resolver that sends queries to the
+// DNS-over-TLS server at server (host:port, port 853 if not given). The
+// server's certificate must be valid for host, which may be a name or
+// an IP address.
+func newDoTResolver(server string, timeout time.Duration,
+	retries int) *dnsResolver {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		server = net.JoinHostPort(server, "853")
+	}
+	return &dnsResolver{server: server, timeout: timeout, retries: retries,
+		tls: &tls.Config{ServerName: host}}
+}
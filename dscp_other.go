@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package viascan
+
+import "errors"
+
+// setDSCP is not supported as the packets can't be marked directly
+func setDSCP(fd uintptr, network string, dscp int) error {
+	return errors.New("-dscp is not supported on this system")
+}
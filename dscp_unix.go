@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package viascan
+
+import "syscall"
+
+// setDSCP marks the packets sent on a socket with a DSCP value
+func setDSCP(fd uintptr, network string, dscp int) error {
+	if network == "tcp6" || network == "udp6" {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+			syscall.IPV6_TCLASS, dscp<<2)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS,
+		dscp<<2)
+}
@@ -0,0 +1,119 @@
+package viascan
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// siteDump collects a readable account of the requests made to a site:
+// their headers, timing and errors. Lines can be added by httptrace
+// callbacks running on the transport's goroutines.
+type siteDump struct {
+	sync.Mutex
+	start time.Time
+	buf   bytes.Buffer
+}
+
+// dumpf adds a line to the dump of s, if it is being dumped, prefixed
+// with the time since the site's test started
+func (s *site) dumpf(format string, a ...interface{}) {
+	d := s.dump
+	if d == nil {
+		return
+	}
+	d.Lock()
+	fmt.Fprintf(&d.buf, "%8.3fs %s\n", time.Since(d.start).Seconds(),
+		fmt.Sprintf(format, a...))
+	d.Unlock()
+}
+
+// dumpHeaders adds the headers in h to the dump of s with each line
+// starting with prefix (> for requests and < for responses)
+func (s *site) dumpHeaders(prefix string, h http.Header) {
+	var names []string
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			s.dumpf("%s %s: %s", prefix, name, v)
+		}
+	}
+}
+
+// dumpDNS adds the whole DNS response m to the lookup of name to the
+// dump of s, with its flags and every section, so that interference
+// with DNS can be shown after the scan
+func (s *site) dumpDNS(name string, m *dns.Msg) {
+	if s.dump == nil {
+		return
+	}
+	if m == nil {
+		s.dumpf("No DNS response for %s from %s", name,
+			s.cfg.resolver.resolver.server)
+		return
+	}
+	s.dumpf("DNS response for %s from %s:\n%s", name,
+		s.cfg.resolver.resolver.server, strings.TrimSpace(m.String()))
+}
+
+// unsafeName matches the characters that are replaced in dump file names
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// dumpName returns the name of the file to which s is dumped
+func (s *site) dumpName() string {
+	name := s.origin + "_" + s.host
+	if s.probe != "" {
+		name += "_" + s.probe
+	}
+	return filepath.Join(s.cfg.dumpDir, unsafeName.ReplaceAllString(name, "_")+".txt")
+}
+
+// saveDump writes the dump of s to its file if its verdict is one of
+// dumpVerdicts
+func (s *site) saveDump() error {
+	if s.dump == nil {
+		return nil
+	}
+	if len(s.cfg.dumpVerdicts) > 0 && !s.cfg.dumpVerdicts[s.verdict()] {
+		return nil
+	}
+
+	s.dumpf("Verdict %s", s.verdict())
+	s.dump.Lock()
+	defer s.dump.Unlock()
+	return ioutil.WriteFile(s.dumpName(), s.dump.buf.Bytes(), 0644)
+}
+
+// parseVerdicts parses the comma separated list given with
+// -dump-verdicts
+func parseVerdicts(list string) (map[string]bool, error) {
+	known := map[string]bool{"unresolved": true, "allowlisted": true,
+		"failed": true, "blocked": true,
+		"decompression_bomb_suspected": true, "encoding": true, "size": true,
+		"identical": true}
+
+	verdicts := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !known[v] {
+			return nil, fmt.Errorf("unknown verdict %s", v)
+		}
+		verdicts[v] = true
+	}
+	return verdicts, nil
+}
@@ -0,0 +1,127 @@
+package viascan
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxEchoBody is the most of an echo response that is read
+const maxEchoBody = 1 << 20
+
+// echoed is the part of an echo response that matters: httpbin writes
+// each header's value as a string and go-httpbin as a list of strings
+type echoed struct {
+	Headers map[string]json.RawMessage `json:"headers"`
+}
+
+// testEcho sends the request with no Via and with Via to the echoPath
+// and returns how the headers that reached the origin differ from the
+// ones sent for each (see echoDiff)
+func (s *site) testEcho(l *os.File, transport http.RoundTripper,
+	req *http.Request) (string, string) {
+	noVia := req.Clone(req.Context())
+	noVia.Header.Del("Via")
+	via := req.Clone(req.Context())
+	via.Header.Set("Via", s.viaToSend())
+
+	// A redirect is not followed as it is not an echo
+
+	client := &http.Client{Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+	return s.echo(l, client, noVia), s.echo(l, client, via)
+}
+
+// echo sends req to the echoPath and returns how the headers echoed
+// back differ from those sent: failed if there was no 2xx response,
+// unparsed if it was not an echo, otherwise see echoDiff
+func (s *site) echo(l *os.File, client *http.Client, req *http.Request) string {
+	u := *req.URL
+	u.Path, u.RawPath, u.RawQuery = s.cfg.echoPath, "", ""
+	req.URL = &u
+
+	s.dumpf("> %s %s (echo)", req.Method, req.URL)
+	s.dumpHeaders(">", req.Header)
+	s.cfg.rateLimits.wait(s.origin)
+	s.cfg.backoffs.wait(s.origin)
+	s.cfg.used.request(s.running)
+	resp, err := client.Do(req.WithContext(s.withSNI(req.Context())))
+	if err != nil {
+		s.logf(l, "Echo %s failed: %s", req.URL, err)
+		return "failed"
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxEchoBody))
+	resp.Body.Close()
+	s.dumpf("< %s %s", resp.Proto, resp.Status)
+	s.dumpHeaders("<", resp.Header)
+	s.cfg.backoffs.record(s.origin, resp)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return "failed"
+	}
+
+	r, ok := s.cfg.decode(body, strings.Join(resp.Header.Values("Content-Encoding"),
+		","))
+	if !ok {
+		return "unparsed"
+	}
+	var e echoed
+	if err := json.NewDecoder(r).Decode(&e); err != nil || e.Headers == nil {
+		return "unparsed"
+	}
+
+	got := make(http.Header)
+	for name, raw := range e.Headers {
+		var one string
+		var list []string
+		if json.Unmarshal(raw, &one) == nil {
+			got.Set(name, one)
+		} else if json.Unmarshal(raw, &list) == nil {
+			got.Set(name, strings.Join(list, ", "))
+		}
+	}
+	return echoDiff(req, got)
+}
+
+// echoDiff returns the names of the headers that were added (+),
+// removed (-) or changed (~) between req and the origin, lowercased,
+// sorted and separated by / (e.g. -via/+x-forwarded-for), or same if
+// none were. The User-Agent is only compared if one was set as Go
+// otherwise sends its own or none.
+func echoDiff(req *http.Request, got http.Header) string {
+	sent := req.Header.Clone()
+	sent.Set("Host", req.Host)
+	if req.Header.Get("User-Agent") == "" {
+		sent.Del("User-Agent")
+		got.Del("User-Agent")
+	}
+
+	var diffs []string
+	for name := range sent {
+		v, ok := got[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, "-"+strings.ToLower(name))
+		case strings.Join(v, ", ") != strings.Join(sent[name], ", "):
+			diffs = append(diffs, "~"+strings.ToLower(name))
+		}
+	}
+	for name := range got {
+		if _, ok := sent[name]; !ok {
+			diffs = append(diffs, "+"+strings.ToLower(name))
+		}
+	}
+	if len(diffs) == 0 {
+		return "same"
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i][1:] < diffs[j][1:]
+	})
+	return strings.Join(diffs, "/")
+}
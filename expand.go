@@ -0,0 +1,250 @@
+package viascan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// dimension is one axis of the probe matrix that an input line is
+// expanded into, for example the list of schemes to try.
+type dimension struct {
+	name   string
+	values []string
+}
+
+// expansion is the full set of dimensions given with -expand. Each
+// input line is expanded into the cartesian product of all the
+// dimensions.
+type expansion []dimension
+
+// dimensions maps the dimension names accepted by -expand to a
+// function that checks a value and applies it to a site
+var dimensions = map[string]func(s *site, v string) error{
+	"schemes": func(s *site, v string) error {
+		if v != "http" && v != "https" {
+			return fmt.Errorf("unknown scheme %s", v)
+		}
+		s.scheme = v
+		return nil
+	},
+	"encodings": func(s *site, v string) error {
+		s.encoding = v
+		return nil
+	},
+	"vias": func(s *site, v string) error {
+		s.viaValue = v
+		return nil
+	},
+	"protocols": func(s *site, v string) error {
+		if v != "h1" && v != "h2" && v != "h3" {
+			return fmt.Errorf("unknown protocol %s", v)
+		}
+		s.proto = v
+		return nil
+	},
+}
+
+// parseExpansion parses an -expand value of the form
+// "schemes=http,https;encodings=gzip,br,identity"
+func parseExpansion(spec string) (expansion, error) {
+	var e expansion
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad expansion %s: expecting name=values", part)
+		}
+		name := strings.TrimSpace(kv[0])
+		apply, ok := dimensions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown expansion dimension %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("expansion dimension %s given more than once", name)
+		}
+		seen[name] = true
+
+		d := dimension{name: name}
+		for _, v := range strings.Split(kv[1], ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if err := apply(&site{}, v); err != nil {
+				return nil, err
+			}
+			d.values = append(d.values, v)
+		}
+		if len(d.values) == 0 {
+			return nil, fmt.Errorf("expansion dimension %s has no values", name)
+		}
+
+		e = append(e, d)
+	}
+
+	return e, nil
+}
+
+// readVias reads the Via values to test from a file (see -via-file),
+// one per line, and returns them as the vias dimension
+func readVias(file string) (dimension, error) {
+	d := dimension{name: "vias"}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return d, err
+	}
+	for _, v := range strings.Split(string(b), "\n") {
+		v = strings.TrimSpace(v)
+		if v != "" && !strings.HasPrefix(v, "#") {
+			d.values = append(d.values, v)
+		}
+	}
+	if len(d.values) == 0 {
+		return d, fmt.Errorf("no Via values in %s", file)
+	}
+	return d, nil
+}
+
+// expand returns the list of sites that need to be tested for a
+// single input line. Each site is tagged with the values from the
+// expansion that were applied to it.
+func (e expansion) expand(base site) []*site {
+	sites := []*site{&base}
+	for _, d := range e {
+		var next []*site
+		for _, s := range sites {
+			for _, v := range d.values {
+				n := *s
+				dimensions[d.name](&n, v)
+				tag := d.name + "=" + v
+				if n.probe != "" {
+					tag = n.probe + ";" + tag
+				}
+				n.probe = tag
+				next = append(next, &n)
+			}
+		}
+		sites = next
+	}
+
+	return sites
+}
+
+// hostVariants are the Host header variants accepted by -expand-hosts
+var hostVariants = map[string]bool{"www": true, "apex": true,
+	"wildcard": true, "punycode": true, "unicode": true,
+	"trailing-dot": true, "uppercase": true}
+
+// wildcardLabel is the random label used for the wildcard Host variant
+var wildcardLabel = fmt.Sprintf("viascan-%08x", rand.Uint32())
+
+// parseHostVariants parses an -expand-hosts value such as
+// "www,apex,wildcard"
+func parseHostVariants(spec string) ([]string, error) {
+	var variants []string
+	for _, v := range strings.Split(spec, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !hostVariants[v] {
+			return nil, fmt.Errorf("unknown host variant %s", v)
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, nil
+}
+
+// hostVariant returns the Host for variant v of host or false if
+// there is no such variant (an IP address has none, say). The www,
+// apex and wildcard variants are names under the same registered
+// domain; punycode, unicode, trailing-dot and uppercase are the same
+// name written in a different but legal way, which shows whether an
+// origin canonicalizes the Host before acting on it.
+func hostVariant(host, v string) (string, bool) {
+	name, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		name, port = h, p
+	}
+	if net.ParseIP(strings.Trim(name, "[]")) != nil {
+		return "", false
+	}
+	withPort := func(n string) (string, bool) {
+		if port != "" {
+			n = net.JoinHostPort(n, port)
+		}
+		return n, true
+	}
+
+	var err error
+	switch v {
+	case "punycode":
+		name, err = idna.Lookup.ToASCII(name)
+	case "unicode":
+		name, err = idna.Display.ToUnicode(name)
+	case "trailing-dot":
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+	case "uppercase":
+		name = strings.ToUpper(name)
+	default:
+		apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(
+			strings.ToLower(name), "."))
+		if err != nil {
+			return "", false
+		}
+		switch v {
+		case "www":
+			name = "www." + apex
+		case "wildcard":
+			name = wildcardLabel + "." + apex
+		default:
+			name = apex
+		}
+	}
+	if err != nil {
+		return "", false
+	}
+
+	return withPort(name)
+}
+
+// expandHosts returns the site for an input line along with a site for
+// each of the Host variants (see hostVariant) that differs from the
+// input line's Host. Variants are tagged in the probe field with host=
+// after any tag already there.
+func expandHosts(base site, variants []string) []site {
+	sites := []site{base}
+	seen := map[string]bool{base.host: true,
+		strings.ToLower(base.host): true}
+	for _, v := range variants {
+		host, ok := hostVariant(base.host, v)
+		if !ok || seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		n := base
+		n.host = host
+		n.probe = "host=" + v
+		if base.probe != "" {
+			n.probe = base.probe + ";" + n.probe
+		}
+		sites = append(sites, n)
+	}
+
+	return sites
+}
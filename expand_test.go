@@ -0,0 +1,100 @@
+package viascan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseExpansion(t *testing.T) {
+	tests := []struct {
+		spec string
+		want expansion
+		err  string
+	}{
+		{"", nil, ""},
+		{" ; ", nil, ""},
+		{"schemes=http,https", expansion{
+			{"schemes", []string{"http", "https"}}}, ""},
+		{"schemes=http,https;encodings=gzip,br,identity", expansion{
+			{"schemes", []string{"http", "https"}},
+			{"encodings", []string{"gzip", "br", "identity"}}}, ""},
+		{" encodings = gzip , br,,identity ;", expansion{
+			{"encodings", []string{"gzip", "br", "identity"}}}, ""},
+		{"protocols=h1,h2,h3", expansion{
+			{"protocols", []string{"h1", "h2", "h3"}}}, ""},
+		{"vias=1.1 varnish, 2 squid", expansion{
+			{"vias", []string{"1.1 varnish", "2 squid"}}}, ""},
+
+		{"schemes", nil, "bad expansion schemes: expecting name=values"},
+		{"colors=red", nil, "unknown expansion dimension colors"},
+		{"schemes=http;schemes=https", nil,
+			"expansion dimension schemes given more than once"},
+		{"schemes=, ,", nil, "expansion dimension schemes has no values"},
+		{"schemes=http,ftp", nil, "unknown scheme ftp"},
+		{"protocols=h1,spdy", nil, "unknown protocol spdy"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpansion(tt.spec)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseExpansion(%q): got error %v, want %s", tt.spec,
+					err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseExpansion(%q) = %v, %v, want %v", tt.spec, got,
+				err, tt.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	e, err := parseExpansion("schemes=http,https;encodings=gzip,identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"http gzip schemes=http;encodings=gzip",
+		"http identity schemes=http;encodings=identity",
+		"https gzip schemes=https;encodings=gzip",
+		"https identity schemes=https;encodings=identity",
+	}
+
+	var got []string
+	for _, s := range e.expand(site{host: "example.com", scheme: "http"}) {
+		if s.host != "example.com" {
+			t.Errorf("expanded site has host %s", s.host)
+		}
+		got = append(got, s.scheme+" "+s.encoding+" "+s.probe)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadVias(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vias")
+	err := os.WriteFile(file, []byte("# Proxies\n1.1 varnish\n\n 2 squid \n"),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := readVias(file)
+	if err != nil || d.name != "vias" ||
+		!reflect.DeepEqual(d.values, []string{"1.1 varnish", "2 squid"}) {
+		t.Errorf("readVias = %v, %v", d, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("# None\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVias(empty); err == nil {
+		t.Errorf("readVias of a file with no Via values succeeded")
+	}
+}
@@ -0,0 +1,73 @@
+package viascan
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// explainCommand implements the explain subcommand which tests a
+// single target with every optional probe and writes what happened at
+// each step (DNS answers, connections, requests and responses) and the
+// result in a readable form, for looking into a surprising result from
+// a scan
+func explainCommand(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	resolverName := fs.String("resolver", "127.0.0.1",
+		"DNS resolver address, DNS-over-HTTPS URL or system, or a comma-separated list of them to fail over between")
+	https := fs.Bool("https", false,
+		"Connect to the origin with https unless the target gives a scheme")
+	via := fs.String("via", "",
+		"Via header value to send (default as scan)")
+	loop := fs.String("cdn-loop", "viascan",
+		"CDN-Loop header value to test with")
+	echo := fs.String("echo-path", "",
+		"Path (e.g. /anything) that echoes request headers as JSON, to compare the headers that reached the origin")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan explain [flags] host,origin\n")
+		return 2
+	}
+
+	scheme := "http"
+	if *https {
+		scheme = "https"
+	}
+	s, err := parseLine(fs.Arg(0), scheme, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad target (%s): %s\n", err, fs.Arg(0))
+		return 2
+	}
+
+	sc := &Scanner{Resolver: *resolverName, Via: *via}
+	cfg := sc.config()
+	verbosity = normal
+	cfg.noCache = "compare"
+	cfg.aeOrder = true
+	cfg.aeMatrix = true
+	cfg.cdnLoop = *loop
+	cfg.forwarded = true
+	cfg.uaCompare = true
+	cfg.dictionary = true
+	cfg.websocket = true
+	cfg.triggerSearch = true
+	cfg.echoPath = *echo
+	s.cfg = cfg
+
+	s.encoding = s.cfg.acceptEncoding()
+	s.dump = &siteDump{start: time.Now()}
+	s.testOrigins(nil)
+
+	fmt.Printf("%s\n", s.dump.buf.String())
+	fmt.Printf("Verdict %s\n\n", s.verdict())
+	names := strings.Split(s.fields(), ",")
+	for i, v := range s.values() {
+		if value := fmt.Sprint(v); value != "" {
+			fmt.Printf("%-20s %s\n", names[i], value)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,30 @@
+package viascan
+
+import (
+	"os"
+	"strings"
+)
+
+// testOrigins tests s and, if its origin does not answer the request
+// with no Via, each of the fallback origins from its input line in
+// turn (see parseLine) until one does. s is left with the results for
+// the last origin tried and the ones tried before it in triedOrigins.
+func (s *site) testOrigins(l *os.File) {
+	base := *s
+	s.test(l)
+	for !s.noVia.yesno && len(s.fallbacks) > 0 {
+		s.logf(l, "No response, falling back to %s", s.fallbacks[0])
+		s.cfg.dead.record(s)
+		next := base
+		next.origin, next.fallbacks = s.fallbacks[0], s.fallbacks[1:]
+		next.triedOrigins = append(s.triedOrigins, s.origin)
+		*s = next
+		s.test(l)
+	}
+}
+
+// tried returns the origins tried before the one s was tested against
+// separated by |
+func (s *site) tried() string {
+	return strings.Join(s.triedOrigins, "|")
+}
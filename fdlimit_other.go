@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package viascan
+
+// fdLimit returns 0 as there is no limit on open files to stay under
+func fdLimit() int {
+	return 0
+}
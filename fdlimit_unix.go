@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+
+package viascan
+
+import "syscall"
+
+// fdLimit returns the process's soft limit on open file descriptors
+// or 0 if there is none
+func fdLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	if rl.Cur > 1<<30 {
+		return 0
+	}
+	return int(rl.Cur)
+}
@@ -0,0 +1,99 @@
+package viascan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+)
+
+// fingerprints are the values of -client-fingerprint: system leaves
+// the client's side of connections to the system and Go's defaults,
+// constant picks the local port and TLS parameters at random once for
+// each site and uses them for all of its connections, and vary picks
+// them afresh for every connection
+var fingerprints = map[string]bool{"system": true, "constant": true,
+	"vary": true}
+
+// clientParams are the parameters of the client's side of a connection
+// chosen by -client-fingerprint. TCP timestamps are not among them as
+// they can only be turned on or off for the whole system.
+type clientParams struct {
+	port    int         // Local port to connect from (0 for the system's choice)
+	tls     uint16      // Highest TLS version offered (0 for Go's default)
+	curve   tls.CurveID // Key exchange offered first
+	tickets bool        // Whether TLS session tickets are offered
+}
+
+// clientKey is the context key for the clientParams of a site when
+// -client-fingerprint=constant (see withSNI)
+type clientKey struct{}
+
+// randomClientParams returns a local port from the range Linux uses
+// for ephemeral ports and a random choice of TLS parameters
+func randomClientParams() clientParams {
+	p := clientParams{port: 32768 + rand.Intn(61000-32768),
+		tls: tls.VersionTLS13, curve: tls.X25519, tickets: rand.Intn(2) == 0}
+	if rand.Intn(2) == 0 {
+		p.tls = tls.VersionTLS12
+	}
+	if rand.Intn(2) == 0 {
+		p.curve = tls.CurveP256
+	}
+	return p
+}
+
+// clientFor returns the parameters for a new connection made for ctx
+func (cfg *config) clientFor(ctx context.Context) clientParams {
+	switch cfg.clientFingerprint {
+	case "constant":
+		if p, ok := ctx.Value(clientKey{}).(clientParams); ok {
+			return p
+		}
+	case "vary":
+		return randomClientParams()
+	}
+	return clientParams{}
+}
+
+// configure sets the TLS parameters of p in c
+func (p clientParams) configure(c *tls.Config) {
+	if p.tls == 0 {
+		return
+	}
+	c.MaxVersion = p.tls
+	c.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+	if p.curve == tls.CurveP256 {
+		c.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.X25519}
+	}
+	c.SessionTicketsDisabled = !p.tickets
+}
+
+// clientSide returns how the client's side of c was set up: its local port
+// and, if -client-fingerprint chose them, the TLS parameters offered
+// (e.g. 40211/tls1.2/p256/tickets), or empty if it is not known
+func clientSide(c net.Conn) string {
+	t := timed(c)
+	if t == nil {
+		return ""
+	}
+	s := ""
+	if a, ok := t.LocalAddr().(*net.TCPAddr); ok {
+		s = fmt.Sprint(a.Port)
+	}
+	if _, ok := c.(*tls.Conn); ok && t.client.tls != 0 {
+		v, curve, tickets := "tls1.3", "x25519", "no-tickets"
+		if t.client.tls == tls.VersionTLS12 {
+			v = "tls1.2"
+		}
+		if t.client.curve == tls.CurveP256 {
+			curve = "p256"
+		}
+		if t.client.tickets {
+			tickets = "tickets"
+		}
+		s += "/" + v + "/" + curve + "/" + tickets
+	}
+	return s
+}
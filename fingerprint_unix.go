@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package viascan
+
+import (
+	"errors"
+	"syscall"
+)
+
+// portInUse returns true if err is from a local port that could not be
+// used because another connection has it
+func portInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) ||
+		errors.Is(err, syscall.EADDRNOTAVAIL)
+}
@@ -0,0 +1,143 @@
+package viascan
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// follower queues up new sites to test when a site redirects to a
+// different host (see -follow-scan-depth) so that a whole set of
+// redirecting sites is covered in one run.
+type follower struct {
+	sync.Mutex
+
+	depth   int             // Maximum depth of redirects to follow
+	seen    map[string]bool // Sites that have already been queued
+	work    chan *site      // Channel on which to queue new sites
+	abort   <-chan struct{} // Closed if the scan is being abandoned
+	counts  *siteCounts     // Counts the sites queued
+	pending sync.WaitGroup  // Sites queued but not yet written out
+}
+
+func newFollower(depth int, work chan *site, abort <-chan struct{},
+	counts *siteCounts) *follower {
+	return &follower{depth: depth, seen: make(map[string]bool),
+		work: work, abort: abort, counts: counts}
+}
+
+// key uniquely identifies the site to prevent redirect loops
+func (s *site) key() string {
+	key := s.scheme + "://" + s.host + "," + s.origin + "," + s.probe
+	if s.ip != nil {
+		key += "," + s.ip.String()
+	}
+	return key
+}
+
+// track records that a site has been queued and returns false if it
+// has been seen before
+func (f *follower) track(s *site) bool {
+	if f.depth == 0 {
+		return true
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.seen[s.key()] {
+		return false
+	}
+	f.seen[s.key()] = true
+	return true
+}
+
+// enqueue queues up a new site for each URL that s redirected to,
+// requesting the path redirected to with the same expansion values
+// (see -expand) as s
+func (f *follower) enqueue(s *site) {
+	if s.depth >= f.depth {
+		return
+	}
+
+	expanded := s.probe
+	if s.path != "" {
+		expanded = strings.TrimPrefix(expanded, "path="+s.path)
+		expanded = strings.TrimPrefix(expanded, ";")
+	}
+	for _, u := range s.redirects {
+		n := withPath(site{cfg: s.cfg, host: u.Hostname(), origin: u.Host,
+			scheme: u.Scheme, encoding: s.encoding, viaValue: s.viaValue,
+			proto: s.proto, depth: s.depth + 1, followedFrom: s.host,
+			line: s.line}, u.RequestURI())
+		if n.probe != "" && expanded != "" {
+			n.probe += ";"
+		}
+		n.probe += expanded
+		if !s.cfg.myShard.mine(n.origin) || !f.track(&n) {
+			continue
+		}
+
+		n.line.add()
+		f.pending.Add(1)
+		atomic.AddInt64(&f.counts.queued, 1)
+		go func() {
+			select {
+			case f.work <- &n:
+			case <-f.abort:
+				f.pending.Done()
+			}
+		}()
+	}
+}
+
+// checkRedirect is used as the http.Client's CheckRedirect to record
+// redirects that go to a different host from the one being tested and
+// to stop following them after maxRedirects, in which case the last
+// redirect is the response
+func (s *site) checkRedirect(req *http.Request, via []*http.Request) error {
+	s.noteRedirect(req.URL)
+	if len(via) > s.cfg.maxRedirects {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// noteRedirect records a redirect to a different host, ignoring the
+// ports of the host and origin
+func (s *site) noteRedirect(to *url.URL) {
+	h := to.Hostname()
+	if h == originHost(s.host) || h == originHost(s.origin) {
+		return
+	}
+	if to.Scheme != "http" && to.Scheme != "https" {
+		return
+	}
+
+	u := *to
+	u.Fragment, u.RawFragment = "", ""
+	for _, r := range s.redirects {
+		if *r == u {
+			return
+		}
+	}
+	s.redirects = append(s.redirects, &u)
+}
+
+// redirectChain returns the redirects that led to resp, each as the
+// status and the URL redirected to (e.g. 301:https://www.example.com/)
+// separated by spaces, and the URL of resp, or empty if there were none
+func redirectChain(resp *http.Response) (string, string) {
+	var hops []string
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		hops = append([]string{fmt.Sprintf("%d:%s", r.Response.StatusCode,
+			r.URL)}, hops...)
+	}
+	if len(hops) == 0 {
+		return "", ""
+	}
+	return strings.Join(hops, " "), resp.Request.URL.String()
+}
@@ -0,0 +1,43 @@
+package viascan
+
+import (
+	"net/url"
+	"testing"
+)
+
+// A site that redirected is followed to the path redirected to, keeping
+// its expansion values
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		path, probe, to string
+
+		want string
+	}{
+		{"", "", "https://www.example.com/",
+			"https://www.example.com,www.example.com,"},
+		{"/a", "path=/a", "http://www.example.com/b?c=1",
+			"http://www.example.com,www.example.com,path=/b?c=1"},
+		{"/a", "path=/a;schemes=http", "http://www.example.com:8080/",
+			"http://www.example.com,www.example.com:8080,schemes=http"},
+		{"", "vias=2 squid", "http://www.example.com/b",
+			"http://www.example.com,www.example.com,path=/b;vias=2 squid"},
+	}
+
+	for _, tt := range tests {
+		work := make(chan *site, 1)
+		f := newFollower(1, work, nil, &siteCounts{})
+		s := &site{cfg: newConfig(), host: "example.com", origin: "192.0.2.1",
+			path: tt.path, probe: tt.probe}
+		to, err := url.Parse(tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.noteRedirect(to)
+		f.enqueue(s)
+		n := <-work
+		if got := n.key(); got != tt.want || n.followedFrom != s.host {
+			t.Errorf("%s from %s %s: got %s from %s, want %s", tt.to, tt.path,
+				tt.probe, got, n.followedFrom, tt.want)
+		}
+	}
+}
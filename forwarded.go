@@ -0,0 +1,59 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// forwardedHeaders are the headers other than Via that proxies add,
+// in the order their results are reported, with the value sent. The
+// address is from TEST-NET-1 so it cannot be anyone's real client.
+var forwardedHeaders = []struct {
+	name  string
+	value string
+}{
+	{"X-Forwarded-For", "192.0.2.1"},
+	{"X-Forwarded-Proto", "https"},
+	{"X-Real-IP", "192.0.2.1"},
+}
+
+// compareTo returns how a response differs from base in the terms used
+// by verdict: blocked (the request failed or got a 403 when base did
+// not), encoding, size or identical
+func compareTo(base, r response, err error) string {
+	switch {
+	case err != nil:
+		return "blocked"
+	case r.status == http.StatusForbidden && base.status != r.status:
+		return "blocked"
+	case r.encoding != base.encoding:
+		return "encoding"
+	case r.size != base.size:
+		return "size"
+	}
+	return "identical"
+}
+
+// testForwarded repeats the request with no Via once with each of the
+// forwardedHeaders and returns how each response differs from the one
+// with no Via separated by / (e.g. identical/encoding/identical), and
+// whether any of them differs in the same way that the response with
+// Via did
+func (s *site) testForwarded(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request,
+	noVia response) (string, bool) {
+	results := make([]string, len(forwardedHeaders))
+	likeVia := false
+	for i, h := range forwardedHeaders {
+		f := req.Clone(req.Context())
+		f.Header.Del("Via")
+		f.Header.Set(h.name, h.value)
+		r, err := s.fetch(l, client, transport, f)
+		results[i] = compareTo(noVia, r, err)
+		likeVia = likeVia || (results[i] != "identical" &&
+			results[i] == s.verdict())
+	}
+
+	return strings.Join(results, "/"), likeVia
+}
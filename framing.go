@@ -0,0 +1,24 @@
+package viascan
+
+import "net/http"
+
+// framing returns how the end of a response body was marked: length
+// (Content-Length), chunked, eof (the connection was closed), h2 or
+// h3 (HTTP/2 or HTTP/3 end of stream with no Content-Length)
+func framing(resp *http.Response) string {
+	for _, te := range resp.TransferEncoding {
+		if te == "chunked" {
+			return "chunked"
+		}
+	}
+	if resp.ContentLength >= 0 {
+		return "length"
+	}
+	if resp.ProtoMajor == 3 {
+		return "h3"
+	}
+	if resp.ProtoMajor == 2 {
+		return "h2"
+	}
+	return "eof"
+}
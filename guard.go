@@ -0,0 +1,114 @@
+package viascan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// guard watches the number of open file descriptors and the size of
+// the heap. When either is above its soft limit intake of new sites is
+// paused until enough work has finished, rather than failing with
+// "too many open files" or running out of memory.
+type guard struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	maxFDs  int    // Soft limit on open file descriptors (0 is no limit)
+	maxHeap uint64 // Soft limit on heap bytes in use (0 is no limit)
+
+	fds  int    // Most recently seen number of open file descriptors
+	heap uint64 // Most recently seen heap size
+
+	peakFDs   int           // Largest number of open file descriptors seen
+	peakHeap  uint64        // Largest heap seen
+	throttled int           // Number of times intake was paused
+	paused    time.Duration // Total time intake was paused
+}
+
+// countFDs returns the number of open file descriptors or -1 if that
+// can't be determined on this system
+func countFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// newGuard starts watching resource usage every interval until stop
+// is closed (never if it is nil)
+func newGuard(maxFDs int, maxHeap uint64, interval time.Duration,
+	stop <-chan struct{}) *guard {
+	g := &guard{maxFDs: maxFDs, maxHeap: maxHeap}
+	g.cond = sync.NewCond(g)
+	g.sample()
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				g.sample()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return g
+}
+
+// sample measures resource usage and wakes anything waiting in wait
+func (g *guard) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fds := countFDs()
+
+	g.Lock()
+	g.fds, g.heap = fds, m.HeapAlloc
+	if fds > g.peakFDs {
+		g.peakFDs = fds
+	}
+	if m.HeapAlloc > g.peakHeap {
+		g.peakHeap = m.HeapAlloc
+	}
+	g.Unlock()
+	g.cond.Broadcast()
+}
+
+// over returns true if usage is above a soft limit. Must be called
+// with the lock held.
+func (g *guard) over() bool {
+	return (g.maxFDs > 0 && g.fds >= g.maxFDs) ||
+		(g.maxHeap > 0 && g.heap >= g.maxHeap)
+}
+
+// wait blocks while resource usage is above a soft limit and busy
+// reports that there is work in progress that will free resources
+func (g *guard) wait(busy func() bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	if !g.over() || !busy() {
+		return
+	}
+
+	g.throttled++
+	start := time.Now()
+	for g.over() && busy() {
+		g.cond.Wait()
+	}
+	g.paused += time.Since(start)
+}
+
+// summary describes the peak resource usage of the run
+func (g *guard) summary() string {
+	g.Lock()
+	defer g.Unlock()
+
+	return fmt.Sprintf("Peak usage: %d open files, %.1f MB heap; intake paused %d times for %s",
+		g.peakFDs, float64(g.peakHeap)/(1<<20), g.throttled,
+		g.paused.Round(time.Millisecond))
+}
@@ -0,0 +1,55 @@
+package viascan
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// h2Transport sends requests over HTTP/2 only: over TLS (offering
+// only h2 in ALPN) for https origins and with prior knowledge (h2c)
+// for http origins, so that origins can be compared for HTTP/1.1 and
+// HTTP/2 clients
+type h2Transport struct {
+	tls *http.Transport
+	h2c *http2.Transport
+}
+
+// roundTripper returns t, which was made by newTransport(proto), an
+// h2Transport using t for https if proto is h2 or an h3Transport if
+// proto is h3
+func (cfg *config) roundTripper(t *http.Transport,
+	proto string) http.RoundTripper {
+	switch proto {
+	case "h2":
+	case "h3":
+		return cfg.newH3Transport()
+	default:
+		return t
+	}
+
+	return &h2Transport{tls: t, h2c: &http2.Transport{
+		AllowHTTP:          true,
+		DisableCompression: true,
+		IdleConnTimeout:    t.IdleConnTimeout,
+		DialTLSContext: func(ctx context.Context, network, addr string,
+			_ *tls.Config) (net.Conn, error) {
+			return cfg.dialOrigin(ctx, network, addr)
+		},
+	}}
+}
+
+func (t *h2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
+}
+
+func (t *h2Transport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
@@ -0,0 +1,76 @@
+package viascan
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// errH3NeedsTLS is returned when an http origin is asked for over
+// HTTP/3, which only runs over TLS
+var errH3NeedsTLS = errors.New("HTTP/3 needs an https origin")
+
+// h3Transport sends requests over HTTP/3 (QUIC) to https origins
+type h3Transport struct {
+	rt *http3.RoundTripper
+}
+
+// newH3Transport creates an h3Transport that resolves origins and sends
+// SNI just as tlsDialer does. As with TLS over TCP certificates are
+// not checked when connecting; fetch records any problem with them.
+func (cfg *config) newH3Transport() *h3Transport {
+	return &h3Transport{rt: &http3.RoundTripper{
+		DisableCompression: true,
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
+		Dial:               cfg.dialQUIC,
+	}}
+}
+
+// dialQUIC connects to an origin over QUIC sending the name asked for
+// by withSNI
+func (cfg *config) dialQUIC(ctx context.Context, addr string,
+	tlsCfg *tls.Config, qc *quic.Config) (quic.EarlyConnection, error) {
+	if cfg.proxyURL != nil {
+		return nil, errH3Proxy
+	}
+	if sni, ok := ctx.Value(sniKey{}).(sniName); ok && sni.addr == addr {
+		tlsCfg = tlsCfg.Clone()
+		tlsCfg.ServerName = sni.name
+	}
+
+	raddr, err := cfg.resolveOrigin(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hctx, cancel := withTimeout(ctx, cfg.timeouts.tls)
+	defer cancel()
+	var c quic.EarlyConnection
+	err = traceTLS(ctx, func() (tls.ConnectionState, error) {
+		var err error
+		if c, err = quic.DialAddrEarly(hctx, raddr, tlsCfg, qc); err != nil {
+			return tls.ConnectionState{}, err
+		}
+		return c.ConnectionState().TLS, nil
+	})
+	if err != nil {
+		return nil, handshakeError{err}
+	}
+
+	return c, nil
+}
+
+func (t *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, errH3NeedsTLS
+	}
+	return t.rt.RoundTrip(req)
+}
+
+func (t *h3Transport) CloseIdleConnections() {
+	t.rt.CloseIdleConnections()
+}
@@ -0,0 +1,113 @@
+package viascan
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// listHeaders are the response headers that are often repeated or
+// hold a list, and whose values are compared between the requests with
+// and without Via taking every value into account rather than just the
+// first. Each has a function that returns its values in a canonical
+// form: Via is a list of hops whose order matters, Cache-Control and
+// Vary are sets of case-insensitive tokens, and only the names of the
+// cookies in Set-Cookie are compared as their values are expected to
+// change from one response to the next.
+var listHeaders = [...]struct {
+	name      string
+	canonical func(values []string) string
+}{
+	{"Via", func(values []string) string {
+		return strings.Join(splitList(values), ",")
+	}},
+	{"Set-Cookie", func(values []string) string {
+		var names []string
+		for _, v := range values {
+			if i := strings.IndexAny(v, "=;"); i >= 0 {
+				v = v[:i]
+			}
+			names = append(names, strings.TrimSpace(v))
+		}
+		return tokenSet(names, false)
+	}},
+	{"Cache-Control", func(values []string) string {
+		return tokenSet(splitList(values), true)
+	}},
+	{"Vary", func(values []string) string {
+		return tokenSet(splitList(values), true)
+	}},
+}
+
+// headerLists holds the canonical values of each of the listHeaders in
+// a response
+type headerLists [len(listHeaders)]string
+
+// lists returns the canonical values of the listHeaders in h
+func lists(h http.Header) headerLists {
+	var l headerLists
+	for i, lh := range listHeaders {
+		if values := h.Values(lh.name); len(values) > 0 {
+			l[i] = lh.canonical(values)
+		}
+	}
+	return l
+}
+
+// get returns the canonical value of the listHeader name in l
+func (l headerLists) get(name string) string {
+	for i, lh := range listHeaders {
+		if lh.name == name {
+			return l[i]
+		}
+	}
+	return ""
+}
+
+// diff returns the lowercased names of the listHeaders whose values
+// differ between l and other separated by / (e.g. set-cookie/vary) or
+// none if they are all the same
+func (l headerLists) diff(other headerLists) string {
+	var names []string
+	for i, lh := range listHeaders {
+		if l[i] != other[i] {
+			names = append(names, strings.ToLower(lh.name))
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "/")
+}
+
+// splitList returns the comma-separated elements of every value with
+// empty ones removed
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+	}
+	return list
+}
+
+// tokenSet returns the distinct tokens sorted, lowercasing them first
+// if fold is set
+func tokenSet(tokens []string, fold bool) string {
+	seen := make(map[string]bool)
+	var set []string
+	for _, t := range tokens {
+		if fold {
+			t = strings.ToLower(t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			set = append(set, t)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
@@ -0,0 +1,249 @@
+package viascan
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openHistory opens (creating if necessary) a history database. It
+// has the same tables as -output-sqlite: every recorded run gets a row
+// in scans and each of its sites a row in results, so a database
+// written with -output-sqlite is also a history.
+func openHistory(file string) (*sql.DB, error) {
+	return openResults(file)
+}
+
+// openSQLite opens (creating if necessary) a SQLite database and
+// creates any tables in schema that it does not have
+func openSQLite(file, schema string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// change is a site whose verdict differs from the previous run it was
+// seen in
+type change struct {
+	time   string
+	origin string
+	host   string
+	probe  string
+	ip     string // Address tested, in diff's output (see -all-ips)
+	before string
+	after  string
+}
+
+func (c change) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s,%s,%s", c.time, c.origin, c.host,
+		c.probe, c.before, c.after)
+}
+
+// changeFields is the header for the change events written by history
+const changeFields = "time,origin,host,probe,before,after"
+
+// recordRun stores a set of results as a new run and returns the
+// sites whose verdict changed since they were last recorded. Only the
+// fields that history and -history-db compare are stored; the other
+// columns of results are left NULL.
+func recordRun(db *sql.DB, r io.Reader, when time.Time) ([]change, int,
+	error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	t := when.UTC().Format(time.RFC3339)
+	res, err := tx.Exec(`INSERT INTO scans (started) VALUES (?)`, t)
+	if err != nil {
+		return nil, 0, err
+	}
+	run, err := res.LastInsertId()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var changes []change
+	n := 0
+	rr := newResultReader(r)
+	for {
+		s, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var before string
+		err = tx.QueryRow(`SELECT verdict FROM results
+			WHERE origin = ? AND host = ? AND probe = ? AND scan < ?
+			ORDER BY scan DESC LIMIT 1`,
+			s.origin, s.host, s.probe, run).Scan(&before)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, 0, err
+		}
+		if err == nil && before != s.verdict() {
+			changes = append(changes, change{time: t, origin: s.origin,
+				host: s.host, probe: s.probe, before: before,
+				after: s.verdict()})
+		}
+
+		_, err = tx.Exec(`INSERT INTO results (scan, time, origin, host,
+			probe, verdict, noViaSize, viaSize, noViaEncoding, viaEncoding,
+			noViaServer, viaServer)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			run, t, s.origin, s.host, s.probe, s.verdict(), s.noViaSize,
+			s.viaSize, s.noViaEncoding, s.viaEncoding, s.noViaServer,
+			s.viaServer)
+		if err != nil {
+			return nil, 0, err
+		}
+		n++
+	}
+
+	return changes, n, tx.Commit()
+}
+
+// changesSince returns every verdict change between consecutive runs
+// of a site that happened after since
+func changesSince(db *sql.DB, since time.Time) ([]change, error) {
+	rows, err := db.Query(`SELECT time, origin, host, probe, before, verdict
+		FROM (SELECT scans.started AS time, origin, host, probe, verdict,
+			LAG(verdict) OVER (PARTITION BY origin, host, probe
+				ORDER BY scan) AS before
+			FROM results JOIN scans ON scans.id = results.scan)
+		WHERE before IS NOT NULL AND before != verdict AND time >= ?
+		ORDER BY time, origin, host, probe`,
+		since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []change
+	for rows.Next() {
+		var c change
+		err := rows.Scan(&c.time, &c.origin, &c.host, &c.probe, &c.before,
+			&c.after)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// annotate sets the last fields of s from the most recent result
+// recorded for the same origin, host and probe in lastRuns. The site
+// counts as changed if its verdict, Content-Encodings or Server headers
+// differ; sizes are not compared as dynamic pages vary in size from run
+// to run.
+func (s *site) annotate(l *os.File) {
+	if s.cfg.lastRuns == nil {
+		return
+	}
+
+	var verdict, noViaEncoding, viaEncoding, noViaServer, viaServer string
+	err := s.cfg.lastRuns.QueryRow(`SELECT scans.started, verdict,
+		noViaEncoding, viaEncoding, noViaServer, viaServer
+		FROM results JOIN scans ON scans.id = results.scan
+		WHERE origin = ? AND host = ? AND probe = ?
+		ORDER BY scan DESC LIMIT 1`,
+		s.origin, s.host, s.probe).Scan(&s.lastSeen, &verdict,
+		&noViaEncoding, &viaEncoding, &noViaServer, &viaServer)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		s.logf(l, "Failed to look up previous result: %s", err)
+		return
+	}
+
+	s.lastVerdict = verdict
+	s.changed = tri{ran: true, yesno: verdict != s.verdict() ||
+		noViaEncoding != s.noViaEncoding || viaEncoding != s.viaEncoding ||
+		noViaServer != s.noViaServer || viaServer != s.viaServer}
+}
+
+// historyCommand implements the history subcommand which keeps the
+// results of every run in a SQLite database and reports sites whose
+// behavior with Via changed
+func historyCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "Usage: viascan history record -db history.db [results.csv]\n")
+		fmt.Fprintf(os.Stderr, "       viascan history changes -db history.db [-days 30]\n")
+		return 2
+	}
+	if len(args) < 1 {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	dbFile := fs.String("db", "viascan.db", "SQLite database holding the history")
+	days := fs.Int("days", 30, "Report changes in this many days (changes only)")
+	fs.Parse(args[1:])
+
+	db, err := openHistory(*dbFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", *dbFile, err)
+		return 1
+	}
+	defer db.Close()
+
+	var changes []change
+	switch args[0] {
+	case "record":
+		in, source := os.Stdin, "stdin"
+		if fs.NArg() == 1 {
+			source = fs.Arg(0)
+			if in, err = os.Open(source); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", source, err)
+				return 1
+			}
+			defer in.Close()
+		}
+
+		var n int
+		changes, n, err = recordRun(db, in, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record %s: %s\n", source, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Recorded %d results, %d changed\n", n,
+			len(changes))
+
+	case "changes":
+		changes, err = changesSince(db,
+			time.Now().AddDate(0, 0, -*days))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to query %s: %s\n", *dbFile, err)
+			return 1
+		}
+
+	default:
+		return usage()
+	}
+
+	if len(changes) > 0 {
+		fmt.Printf("%s\n", changeFields)
+	}
+	for _, c := range changes {
+		fmt.Printf("%s\n", c)
+	}
+
+	return 0
+}
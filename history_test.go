@@ -0,0 +1,66 @@
+package viascan
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// History is kept in the same tables as -output-sqlite so either can
+// be recorded into the same database
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.db")
+	db, err := openHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	header := "origin,host,resolves,noVia,via,noViaSize,viaSize\n"
+	runs := []string{
+		"192.0.2.1,example.com,t,t,t,10,10\n192.0.2.2,example.org,t,t,t,5,5\n",
+		"192.0.2.1,example.com,t,t,f,10,0\n192.0.2.2,example.org,t,t,t,5,5\n",
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var changes []change
+	for i, r := range runs {
+		var n int
+		changes, n, err = recordRun(db, strings.NewReader(header+r),
+			start.AddDate(0, 0, i))
+		if err != nil || n != 2 {
+			t.Fatalf("recordRun %d: %d results, %v", i, n, err)
+		}
+	}
+	want := "2024-01-02T00:00:00Z,192.0.2.1,example.com,,identical,blocked"
+	if len(changes) != 1 || changes[0].String() != want {
+		t.Errorf("recordRun: got changes %v, want %s", changes, want)
+	}
+
+	store, err := openResultDB(file, start.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &site{cfg: newConfig(), origin: "192.0.2.2", host: "example.org",
+		resolves: tri{true, true}, noVia: tri{true, true},
+		via: tri{true, true}, noViaSize: 5, viaSize: 6}
+	if err := store.write(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = changesSince(db, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want += " 2024-01-03T00:00:00Z,192.0.2.2,example.org,,identical,size"
+	if strings.Join(got, " ") != want {
+		t.Errorf("changesSince: got %q, want %s", got, want)
+	}
+}
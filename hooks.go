@@ -0,0 +1,53 @@
+package viascan
+
+import "net"
+
+// Hooks are called as each site is tested so that code embedding the
+// scanner can add its own telemetry or persistence, or stop testing a
+// site early, without changing the scanner. Any of them may be nil.
+// They are called from the workers so must be safe for concurrent use.
+//
+// The probes are no-via, via and the optional ae-matrix, ae-order,
+// cdn-loop, dictionary, echo, forwarded, no-cache, trigger, ua-compare
+// and websocket.
+type Hooks struct {
+	// OnResolve is called when the origin has been looked up (ips holds
+	// the origin itself if it is an IP address)
+	OnResolve func(origin string, ips []net.IP, err error)
+
+	// OnProbeStart is called before a probe runs. If it returns false
+	// the probe is not run; skipping no-via or via ends the site's test.
+	OnProbeStart func(origin, host, probe string) bool
+
+	// OnProbeComplete is called after a probe has run with the status
+	// of its response (0 for the optional probes, which make several
+	// requests) or the error if its request failed
+	OnProbeComplete func(origin, host, probe string, status int, err error)
+
+	// OnVerdict is called once a site has been tested
+	OnVerdict func(origin, host, probe, verdict string)
+}
+
+func (s *site) resolved(ips []net.IP, err error) {
+	if s.cfg.hooks.OnResolve != nil {
+		s.cfg.hooks.OnResolve(s.origin, ips, err)
+	}
+}
+
+func (s *site) probeStart(probe string) bool {
+	s.running = probe
+	return s.cfg.hooks.OnProbeStart == nil ||
+		s.cfg.hooks.OnProbeStart(s.origin, s.host, probe)
+}
+
+func (s *site) probeComplete(probe string, status int, err error) {
+	if s.cfg.hooks.OnProbeComplete != nil {
+		s.cfg.hooks.OnProbeComplete(s.origin, s.host, probe, status, err)
+	}
+}
+
+func (s *site) verdictReached() {
+	if s.cfg.hooks.OnVerdict != nil {
+		s.cfg.hooks.OnVerdict(s.origin, s.host, s.probe, s.verdict())
+	}
+}
@@ -0,0 +1,176 @@
+package viascan
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parseLine parses an input line into the site to test. A line is
+// either a Host and an origin separated by a comma, where the origin
+// may start with a scheme and end with a path (for example
+// example.com,https://192.0.2.1/app.js), or a full URL on its own (for
+// example https://example.com/app.js) which is fetched from the host in
+// the URL. Origins with no scheme get scheme. The origin may be a list
+// of origins separated by | (for example
+// example.com,https://192.0.2.1|192.0.2.2) which are tried in order
+// (see testOrigins); the others use the first's scheme and path unless
+// they give the same ones. A host and origin may be followed by the IP
+// address to connect to (for example example.com,example.com,192.0.2.1)
+// so that the origin is not looked up but is still used in the URL.
+// Origins may give a port (for example example.com,192.0.2.1:8080) and
+// those that do not, other than in full URLs, get port if it is not
+// empty (see -port). It returns why if the line is not understood.
+func parseLine(line, scheme, port string) (site, error) {
+	parts := strings.Split(line, ",")
+	switch len(parts) {
+	case 1:
+		u, err := url.Parse(strings.TrimSpace(parts[0]))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			return site{}, errors.New("not host,origin or an http(s) URL")
+		}
+		origin, err := normalOrigin(u.Host, "")
+		if err != nil {
+			return site{}, err
+		}
+		return withPath(site{host: u.Host, origin: origin,
+			scheme: u.Scheme}, u.RequestURI()), nil
+	case 3:
+		ip := net.ParseIP(strings.TrimSpace(parts[2]))
+		if ip == nil {
+			return site{}, errors.New("not an IP address: " + parts[2])
+		}
+		s, err := parseLine(parts[0]+","+parts[1], scheme, port)
+		if err != nil {
+			return site{}, err
+		}
+		if len(s.fallbacks) > 0 {
+			return site{}, errors.New("an IP address with fallback origins")
+		}
+		s.ip = ip
+		return s, nil
+	case 2:
+		s := site{host: parts[0]}
+		path := ""
+		for i, alt := range strings.Split(parts[1], "|") {
+			sc, origin := splitScheme(alt, scheme)
+			if i > 0 {
+				sc, origin = splitScheme(alt, s.scheme)
+			}
+			p := ""
+			if j := strings.Index(origin, "/"); j >= 0 {
+				origin, p = origin[:j], origin[j:]
+				if _, err := url.ParseRequestURI(p); err != nil {
+					return site{}, errors.New("bad path: " + p)
+				}
+			}
+			if origin == "" {
+				return site{}, errors.New("empty origin")
+			}
+			origin, err := normalOrigin(origin, port)
+			if err != nil {
+				return site{}, err
+			}
+			if i == 0 {
+				s.scheme, s.origin, path = sc, origin, p
+				continue
+			}
+			if sc != s.scheme || (p != "" && p != path) {
+				return site{}, errors.New("origins with different schemes or paths")
+			}
+			s.fallbacks = append(s.fallbacks, origin)
+		}
+		return withPath(s, path), nil
+	}
+
+	return site{}, errors.New("too many fields")
+}
+
+// withPath returns s set to request path rather than /. Paths other
+// than / are recorded in the probe field (e.g. path=/app.js) so that
+// results for different paths on the same site can be told apart.
+func withPath(s site, path string) site {
+	if path == "" || path == "/" {
+		return s
+	}
+	s.path = path
+	s.probe = "path=" + path
+	return s
+}
+
+// splitScheme splits an origin given in an input line into its scheme
+// and the rest of the origin. Origins with no scheme get scheme.
+func splitScheme(origin, scheme string) (string, string) {
+	for _, sc := range []string{"http", "https"} {
+		if strings.HasPrefix(origin, sc+"://") {
+			return sc, origin[len(sc)+3:]
+		}
+	}
+	return scheme, origin
+}
+
+// lineReader reads input lines of up to max bytes. Unlike
+// bufio.Scanner it does not stop at a longer line but returns its
+// first max bytes and its length so that it can be skipped.
+type lineReader struct {
+	r    *bufio.Reader
+	max  int
+	line []byte
+	size int // Length of the line read
+	err  error
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, 64<<10), max: max}
+}
+
+// next reads the next line, without its line ending, returning false
+// at the end of the input or on an error
+func (lr *lineReader) next() bool {
+	lr.line = lr.line[:0]
+	lr.size = 0
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		last := err != bufio.ErrBufferFull
+		if last && len(chunk) > 0 && chunk[len(chunk)-1] == '\n' {
+			chunk = chunk[:len(chunk)-1]
+			if len(chunk) > 0 && chunk[len(chunk)-1] == '\r' {
+				chunk = chunk[:len(chunk)-1]
+			}
+			err = nil
+		}
+		lr.size += len(chunk)
+		if room := lr.max - len(lr.line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			lr.line = append(lr.line, chunk...)
+		}
+		if last {
+			if err == io.EOF && lr.size > 0 {
+				err = nil
+			}
+			if err != nil {
+				if err != io.EOF {
+					lr.err = err
+				}
+				return false
+			}
+			return true
+		}
+	}
+}
+
+// text returns the line read, cut to max bytes if it was longer
+func (lr *lineReader) text() string {
+	return string(lr.line)
+}
+
+// tooLong returns whether the line read was longer than max bytes
+func (lr *lineReader) tooLong() bool {
+	return lr.size > lr.max
+}
@@ -0,0 +1,170 @@
+package viascan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line, scheme, port string
+
+		host, origin, want, path, probe, ip string
+		fallbacks                           []string
+		err                                 string
+	}{
+		{line: "example.com,192.0.2.1", scheme: "http",
+			host: "example.com", origin: "192.0.2.1", want: "http"},
+		{line: "example.com,192.0.2.1", scheme: "https",
+			host: "example.com", origin: "192.0.2.1", want: "https"},
+		{line: "example.com,https://192.0.2.1", scheme: "http",
+			host: "example.com", origin: "192.0.2.1", want: "https"},
+		{line: "example.com,http://192.0.2.1", scheme: "https",
+			host: "example.com", origin: "192.0.2.1", want: "http"},
+		{line: "example.com,https://192.0.2.1/app.js", scheme: "http",
+			host: "example.com", origin: "192.0.2.1", want: "https",
+			path: "/app.js", probe: "path=/app.js"},
+		{line: "example.com,192.0.2.1/", scheme: "http",
+			host: "example.com", origin: "192.0.2.1", want: "http"},
+		{line: "https://example.com/app.js?v=1", scheme: "http",
+			host: "example.com", origin: "example.com", want: "https",
+			path: "/app.js?v=1", probe: "path=/app.js?v=1"},
+		{line: "http://example.com", scheme: "https",
+			host: "example.com", origin: "example.com", want: "http"},
+
+		{line: "example.com,192.0.2.1", scheme: "http", port: "8080",
+			host: "example.com", origin: "192.0.2.1:8080", want: "http"},
+		{line: "example.com,192.0.2.1:8443", scheme: "http", port: "8080",
+			host: "example.com", origin: "192.0.2.1:8443", want: "http"},
+		{line: "example.com,2001:db8::1", scheme: "http",
+			host: "example.com", origin: "[2001:db8::1]", want: "http"},
+		{line: "https://example.com:8443/", scheme: "http", port: "8080",
+			host: "example.com:8443", origin: "example.com:8443",
+			want: "https"},
+
+		{line: "example.com,https://192.0.2.1|192.0.2.2", scheme: "http",
+			host: "example.com", origin: "192.0.2.1", want: "https",
+			fallbacks: []string{"192.0.2.2"}},
+		{line: "example.com,192.0.2.1/a|192.0.2.2|192.0.2.3/a",
+			scheme: "http", host: "example.com", origin: "192.0.2.1",
+			want: "http", path: "/a", probe: "path=/a",
+			fallbacks: []string{"192.0.2.2", "192.0.2.3"}},
+		{line: "example.com,192.0.2.1|192.0.2.2", scheme: "http",
+			port: "8080", host: "example.com", origin: "192.0.2.1:8080",
+			want: "http", fallbacks: []string{"192.0.2.2:8080"}},
+		{line: "example.com,https://192.0.2.1|http://192.0.2.2",
+			scheme: "http", err: "origins with different schemes or paths"},
+		{line: "example.com,192.0.2.1/a|192.0.2.2/b", scheme: "http",
+			err: "origins with different schemes or paths"},
+		{line: "example.com,192.0.2.1|", scheme: "http",
+			err: "empty origin"},
+
+		{line: "example.com,example.com,192.0.2.1", scheme: "http",
+			host: "example.com", origin: "example.com", want: "http",
+			ip: "192.0.2.1"},
+		{line: "example.com,https://example.com/a, 2001:db8::1",
+			scheme: "http", host: "example.com", origin: "example.com",
+			want: "https", path: "/a", probe: "path=/a",
+			ip: "2001:db8::1"},
+		{line: "example.com,example.com,nope", scheme: "http",
+			err: "not an IP address: nope"},
+		{line: "example.com,192.0.2.1|192.0.2.2,192.0.2.3",
+			scheme: "http", err: "an IP address with fallback origins"},
+
+		{line: "example.com,192.0.2.1/%zz", scheme: "http",
+			err: "bad path: /%zz"},
+		{line: "example.com,https:///app.js", scheme: "http",
+			err: "empty origin"},
+		{line: "example.com,192.0.2.1:99999", scheme: "http",
+			err: "bad port in origin 192.0.2.1:99999"},
+		{line: "example.com", scheme: "http",
+			err: "not host,origin or an http(s) URL"},
+		{line: "ftp://example.com/", scheme: "http",
+			err: "not host,origin or an http(s) URL"},
+		{line: "a,b,192.0.2.1,d", scheme: "http", err: "too many fields"},
+	}
+
+	for _, tt := range tests {
+		s, err := parseLine(tt.line, tt.scheme, tt.port)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseLine(%q): got error %v, want %s", tt.line, err,
+					tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLine(%q): %s", tt.line, err)
+			continue
+		}
+		ip := ""
+		if s.ip != nil {
+			ip = s.ip.String()
+		}
+		if s.host != tt.host || s.origin != tt.origin ||
+			s.scheme != tt.want || s.path != tt.path || s.probe != tt.probe ||
+			ip != tt.ip || !reflect.DeepEqual(s.fallbacks, tt.fallbacks) {
+			t.Errorf("parseLine(%q) = host %q origin %q scheme %q path %q "+
+				"probe %q ip %q fallbacks %q", tt.line, s.host, s.origin,
+				s.scheme, s.path, s.probe, ip, s.fallbacks)
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	tests := []struct {
+		input string
+		max   int
+		lines []string
+		sizes []int
+	}{
+		{"a,b\nc,d\n", 10, []string{"a,b", "c,d"}, []int{3, 3}},
+		{"a,b\r\nc,d", 10, []string{"a,b", "c,d"}, []int{3, 3}},
+		{"\n\na\n", 10, []string{"", "", "a"}, []int{0, 0, 1}},
+		{"0123456789\n", 10, []string{"0123456789"}, []int{10}},
+		{"0123456789a\nb\n", 10, []string{"0123456789", "b"}, []int{11, 1}},
+		{long + "\n" + long, 5, []string{"xxxxx", "xxxxx"}, []int{20, 20}},
+		{"", 10, nil, nil},
+	}
+
+	for _, tt := range tests {
+		lr := newLineReader(strings.NewReader(tt.input), tt.max)
+		var lines []string
+		var sizes []int
+		for lr.next() {
+			lines = append(lines, lr.text())
+			sizes = append(sizes, lr.size)
+			if lr.tooLong() != (lr.size > tt.max) {
+				t.Errorf("%q: tooLong() is %t for a %d byte line", tt.input,
+					lr.tooLong(), lr.size)
+			}
+		}
+		if lr.err != nil {
+			t.Errorf("%q: %s", tt.input, lr.err)
+		}
+		if !reflect.DeepEqual(lines, tt.lines) ||
+			!reflect.DeepEqual(sizes, tt.sizes) {
+			t.Errorf("%q with max %d: got %q %v, want %q %v", tt.input,
+				tt.max, lines, sizes, tt.lines, tt.sizes)
+		}
+	}
+}
+
+// A line longer than the reader's buffer is still cut to max and
+// measured in full
+func TestLineReaderLongLine(t *testing.T) {
+	line := strings.Repeat("y", 200<<10)
+	lr := newLineReader(strings.NewReader(line+"\nz\n"), 1<<10)
+	if !lr.next() || len(lr.text()) != 1<<10 || lr.size != len(line) ||
+		!lr.tooLong() {
+		t.Fatalf("got %d bytes of a %d byte line", len(lr.text()), lr.size)
+	}
+	if !lr.next() || lr.text() != "z" || lr.tooLong() {
+		t.Fatalf("got %q after the long line", lr.text())
+	}
+	if lr.next() {
+		t.Fatalf("got %q after the end", lr.text())
+	}
+}
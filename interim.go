@@ -0,0 +1,11 @@
+package viascan
+
+// interim returns the status codes of the 1xx responses (such as 103
+// Early Hints) received before a response, in order and separated by
+// /, or none. Intermediaries that detect Via sometimes suppress them.
+func interim(codes string) string {
+	if codes == "" {
+		return "none"
+	}
+	return codes
+}
@@ -0,0 +1,148 @@
+package viascan
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaWindow is the period over which an API key's quota is counted
+const quotaWindow = time.Hour
+
+// apiKey is a client of serve, read from -keys. Jobs belong to the key
+// that submitted them and only that key can see them.
+type apiKey struct {
+	name    string
+	key     string
+	quota   int  // Sites that may be submitted in quotaWindow (0 for no limit)
+	control bool // Whether it may use /control (see -control)
+
+	sync.Mutex
+	used []submission // Within the last quotaWindow, oldest first
+}
+
+// submission records the number of sites in a job and when it was
+// submitted for counting against a quota
+type submission struct {
+	at    time.Time
+	sites int
+}
+
+// readKeys reads the API keys in file. Each line has a name for the
+// client, the key it sends and optionally the number of sites it may
+// submit an hour.
+func readKeys(file string) ([]*apiKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*apiKey
+	names := make(map[string]bool)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 && len(f) != 3 {
+			return nil, fmt.Errorf("line %d: expecting name, key and quota", i+1)
+		}
+		k := &apiKey{name: f[0], key: f[1]}
+		if names[k.name] {
+			return nil, fmt.Errorf("line %d: %s is given twice", i+1, k.name)
+		}
+		names[k.name] = true
+		if len(f[1]) < 16 {
+			return nil, fmt.Errorf("line %d: key is shorter than 16 characters", i+1)
+		}
+		if len(f) == 3 {
+			if k.quota, err = strconv.Atoi(f[2]); err != nil || k.quota < 0 {
+				return nil, fmt.Errorf("line %d: bad quota %s", i+1, f[2])
+			}
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", file)
+	}
+	return keys, nil
+}
+
+// findKey returns the key sent as a bearer token in r's Authorization
+// header or nil if there is none or it is not one of keys. Every key
+// is compared so that the time taken does not say which is closest.
+func findKey(keys []*apiKey, r *http.Request) *apiKey {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil
+	}
+	sent := []byte(strings.TrimSpace(auth[7:]))
+	var found *apiKey
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(sent, []byte(k.key)) == 1 {
+			found = k
+		}
+	}
+	return found
+}
+
+// take counts sites against the key's quota at now. If that would go
+// over the quota nothing is counted and it returns false with how long
+// until enough of the quota is free again (0 if it never will be).
+func (k *apiKey) take(sites int, now time.Time) (bool, time.Duration) {
+	if k.quota == 0 {
+		return true, 0
+	}
+	if sites > k.quota {
+		return false, 0
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	for len(k.used) > 0 && now.Sub(k.used[0].at) >= quotaWindow {
+		k.used = k.used[1:]
+	}
+	total := sites
+	for _, u := range k.used {
+		total += u.sites
+	}
+	if total <= k.quota {
+		k.used = append(k.used, submission{at: now, sites: sites})
+		return true, 0
+	}
+	for _, u := range k.used {
+		if total -= u.sites; total <= k.quota {
+			return false, u.at.Add(quotaWindow).Sub(now)
+		}
+	}
+	return false, 0
+}
+
+// refund removes the sites last counted by take, for a job that could
+// not be queued after all
+func (k *apiKey) refund() {
+	k.Lock()
+	if n := len(k.used); n > 0 {
+		k.used = k.used[:n-1]
+	}
+	k.Unlock()
+}
+
+// countSites returns the number of input lines in input, which is what
+// a job counts against a quota
+func countSites(input []byte) int {
+	n := 0
+	for _, line := range bytes.Split(input, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			n++
+		}
+	}
+	return n
+}
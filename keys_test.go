@@ -0,0 +1,114 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadKeys(t *testing.T) {
+	tests := []struct {
+		keys string
+		err  string
+	}{
+		{"# name key quota\nops 0123456789abcdef0123\n\nteam abcdefabcdefabcdef12 100\n", ""},
+		{"ops 0123456789abcdef\nops abcdefabcdefabcdef", "line 2: ops is given twice"},
+		{"ops short", "line 1: key is shorter than 16 characters"},
+		{"ops 0123456789abcdef0123 many", "line 1: bad quota many"},
+		{"ops 0123456789abcdef0123 -1", "line 1: bad quota -1"},
+		{"ops", "line 1: expecting name, key and quota"},
+		{"# none\n", "no keys in "},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		file := filepath.Join(dir, "keys")
+		if err := os.WriteFile(file, []byte(tt.keys), 0600); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := readKeys(file)
+		if tt.err != "" {
+			if tt.err == "no keys in " {
+				tt.err += file
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %s", tt.keys, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.keys, err)
+			continue
+		}
+		if len(keys) != 2 || keys[0].name != "ops" || keys[0].quota != 0 ||
+			keys[1].key != "abcdefabcdefabcdef12" || keys[1].quota != 100 {
+			t.Errorf("%q: got %+v %+v", tt.keys, keys[0], keys[1])
+		}
+	}
+}
+
+func TestFindKey(t *testing.T) {
+	keys := []*apiKey{{name: "ops", key: "0123456789abcdef0123"},
+		{name: "team", key: "abcdefabcdefabcdef12"}}
+	tests := []struct {
+		auth string
+		want string
+	}{
+		{"Bearer abcdefabcdefabcdef12", "team"},
+		{"bearer  0123456789abcdef0123 ", "ops"},
+		{"Bearer 0123456789abcdef012", ""},
+		{"Basic 0123456789abcdef0123", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		got := ""
+		if k := findKey(keys, r); k != nil {
+			got = k.name
+		}
+		if got != tt.want {
+			t.Errorf("%q: got key %q, want %q", tt.auth, got, tt.want)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	k := &apiKey{quota: 10}
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		sites int
+		ok    bool
+		wait  time.Duration
+	}{
+		{0, 4, true, 0},
+		{10 * time.Minute, 5, true, 0},
+		{20 * time.Minute, 2, false, 40 * time.Minute},
+		{30 * time.Minute, 11, false, 0},
+		{61 * time.Minute, 5, true, 0},
+		{62 * time.Minute, 1, false, 8 * time.Minute},
+	}
+	for _, s := range steps {
+		ok, wait := k.take(s.sites, start.Add(s.after))
+		if ok != s.ok || wait != s.wait {
+			t.Errorf("%d sites after %s: got %t %s, want %t %s", s.sites,
+				s.after, ok, wait, s.ok, s.wait)
+		}
+	}
+
+	k.refund()
+	if ok, _ := k.take(5, start.Add(63*time.Minute)); !ok {
+		t.Errorf("refunded sites were still counted")
+	}
+}
+
+func TestCountSites(t *testing.T) {
+	if n := countSites([]byte("a,b\n\n  \nc,d\r\ne,f")); n != 3 {
+		t.Errorf("got %d sites, want 3", n)
+	}
+}
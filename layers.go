@@ -0,0 +1,80 @@
+package viascan
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// codings splits a Content-Encoding header, which may have been sent
+// more than once and joined with commas, into its list of codings in
+// the order they were applied
+func codings(encoding string) []string {
+	var list []string
+	for _, c := range strings.Split(encoding, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// normalEncoding returns a Content-Encoding header with the codings
+// joined by + (e.g. gzip+deflate) so that it can be written as a single
+// field and compared regardless of spacing and case
+func normalEncoding(encoding string) string {
+	return strings.Join(codings(encoding), "+")
+}
+
+// checkLayers checks that a body with more than one coding in its
+// Content-Encoding header can be decoded by undoing the codings in
+// reverse order. It returns ok, misordered (the body only decodes if
+// the codings are undone in the order listed), undecodable, unknown (a
+// coding viascan cannot decode such as br) or empty if the body did
+// not have more than one coding.
+func (cfg *config) checkLayers(body []byte, encoding string) string {
+	list := codings(encoding)
+	if len(list) < 2 {
+		return ""
+	}
+	for _, c := range list {
+		switch c {
+		case "gzip", "x-gzip", "deflate", "identity":
+		default:
+			return "unknown"
+		}
+	}
+
+	if cfg.decodes(body, list) {
+		return "ok"
+	}
+
+	reversed := make([]string, len(list))
+	for i, c := range list {
+		reversed[len(list)-1-i] = c
+	}
+	if cfg.decodes(body, reversed) {
+		return "misordered"
+	}
+
+	return "undecodable"
+}
+
+// decodes returns true if body decodes cleanly when the codings in
+// list are undone in reverse order. A body that hits the decompression
+// limits counts as decoding since its layers were undone.
+func (cfg *config) decodes(body []byte, list []string) bool {
+	r, ok := cfg.decode(body, strings.Join(list, ","))
+	if !ok {
+		return false
+	}
+	_, err := io.Copy(ioutil.Discard, r)
+	return err == nil || err == errBomb
+}
+
+// badLayers returns true if checkLayers found that stacked codings
+// were wrong
+func badLayers(layers string) bool {
+	return layers == "misordered" || layers == "undecodable"
+}
@@ -0,0 +1,160 @@
+package viascan
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// sizeBuckets are the upper bounds in bytes of the buckets of the
+// response size histogram served by -metrics-addr
+var sizeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10,
+	1 << 20, 4 << 20, 16 << 20}
+
+// sizeHistogram counts response sizes in sizeBuckets
+type sizeHistogram struct {
+	counts []int // Count in each bucket, not cumulative
+	count  int
+	sum    int
+}
+
+func (h *sizeHistogram) observe(size int) {
+	if h.counts == nil {
+		h.counts = make([]int, len(sizeBuckets))
+	}
+	for i, le := range sizeBuckets {
+		if size <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += size
+}
+
+// liveMetrics are the counts served while the scan runs by
+// -metrics-addr, along with those of the rollup
+type liveMetrics struct {
+	sync.Mutex
+	roll *rollup
+
+	sites              int            // Sites written
+	dnsFailures        int            // Sites whose origin did not resolve
+	httpFailures       map[string]int // Failed requests by probe
+	encodingMismatches int            // Sites with the verdict encoding
+	sizeMismatches     int            // Sites with the verdict size
+
+	sizes map[string]*sizeHistogram // Sizes of bodies by probe
+}
+
+func newLiveMetrics(roll *rollup) *liveMetrics {
+	return &liveMetrics{roll: roll, httpFailures: make(map[string]int),
+		sizes: map[string]*sizeHistogram{"no-via": {}, "via": {}}}
+}
+
+// record counts s
+func (m *liveMetrics) record(s *site) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.sites++
+	if s.resolves.ran && !s.resolves.yesno {
+		m.dnsFailures++
+	}
+	switch s.verdict() {
+	case "encoding":
+		m.encodingMismatches++
+	case "size":
+		m.sizeMismatches++
+	}
+
+	for _, p := range []struct {
+		probe  string
+		worked tri
+		size   int
+	}{{"no-via", s.noVia, s.noViaSize}, {"via", s.via, s.viaSize}} {
+		if !p.worked.ran {
+			continue
+		}
+		if !p.worked.yesno {
+			m.httpFailures[p.probe]++
+			continue
+		}
+		m.sizes[p.probe].observe(p.size)
+	}
+}
+
+// prometheus writes the counts to w in the Prometheus text format
+func (m *liveMetrics) prometheus(w io.Writer) {
+	m.Lock()
+	counter := func(name, help string, v int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help,
+			name, name, v)
+	}
+	counter("viascan_sites_total", "Sites scanned.", m.sites)
+	counter("viascan_dns_failures_total",
+		"Sites whose origin did not resolve.", m.dnsFailures)
+	counter("viascan_encoding_mismatches_total",
+		"Sites whose Content-Encoding differed with Via.",
+		m.encodingMismatches)
+	counter("viascan_size_mismatches_total",
+		"Sites whose body size differed with Via.", m.sizeMismatches)
+
+	fmt.Fprintf(w, "# HELP viascan_http_failures_total Requests that failed by request.\n")
+	fmt.Fprintf(w, "# TYPE viascan_http_failures_total counter\n")
+	for _, probe := range []string{"no-via", "via"} {
+		fmt.Fprintf(w, "viascan_http_failures_total{probe=%q} %d\n", probe,
+			m.httpFailures[probe])
+	}
+
+	fmt.Fprintf(w, "# HELP viascan_response_size_bytes Sizes of the bodies of responses by request.\n")
+	fmt.Fprintf(w, "# TYPE viascan_response_size_bytes histogram\n")
+	for _, probe := range []string{"no-via", "via"} {
+		h := m.sizes[probe]
+		total := 0
+		for i, le := range sizeBuckets {
+			if h.counts != nil {
+				total += h.counts[i]
+			}
+			fmt.Fprintf(w, "viascan_response_size_bytes_bucket{probe=%q,le=\"%d\"} %d\n",
+				probe, le, total)
+		}
+		fmt.Fprintf(w, "viascan_response_size_bytes_bucket{probe=%q,le=\"+Inf\"} %d\n",
+			probe, h.count)
+		fmt.Fprintf(w, "viascan_response_size_bytes_sum{probe=%q} %d\n", probe,
+			h.sum)
+		fmt.Fprintf(w, "viascan_response_size_bytes_count{probe=%q} %d\n",
+			probe, h.count)
+	}
+	m.Unlock()
+
+	m.roll.prometheus(w)
+}
+
+// serveMetrics serves the counts on /metrics at addr until the scan
+// ends. The listener is opened before returning so that a bad address
+// stops the scan before it starts.
+func (m *liveMetrics) serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.prometheus(w)
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			warnf("-metrics-addr stopped serving: %s\n", err)
+		}
+	}()
+	return nil
+}
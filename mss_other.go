@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package viascan
+
+import "errors"
+
+// setMSS is not supported as the socket option is not available
+func setMSS(fd uintptr, mss int) error {
+	return errors.New("-tcp-mss is not supported on this system")
+}
+
+// getMSS always returns 0 as the MSS is not known
+func getMSS(fd uintptr) int {
+	return 0
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package viascan
+
+import "syscall"
+
+// setMSS sets the largest segment a socket sends and advertises
+func setMSS(fd uintptr, mss int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP,
+		syscall.TCP_MAXSEG, mss)
+}
+
+// getMSS returns the segment size a connected socket is sending with
+func getMSS(fd uintptr) int {
+	mss, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP,
+		syscall.TCP_MAXSEG)
+	if err != nil {
+		return 0
+	}
+	return mss
+}
@@ -0,0 +1,62 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+)
+
+// setNoCache adds the request headers that ask caches along the way
+// not to serve a stored response
+func setNoCache(req *http.Request) {
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+}
+
+// compareNoCache repeats req with the no-cache headers added and
+// reports whether the response differs from orig. A difference points
+// to a caching layer rather than the origin's own handling of Via.
+func (s *site) compareNoCache(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request, orig response) tri {
+	nc := req.Clone(req.Context())
+	setNoCache(nc)
+
+	r, err := s.fetch(l, client, transport, nc)
+	if err != nil {
+		return tri{}
+	}
+
+	return tri{ran: true, yesno: !sameResponse(r, orig)}
+}
+
+// sameResponse returns true if a and b are the same response as far as
+// the no-cache comparison goes. Timings and the connection always
+// differ and no-cache is expected to change headers such as
+// Cache-Control so only these are compared.
+func sameResponse(a, b response) bool {
+	return a.status == b.status && a.size == b.size &&
+		a.encoding == b.encoding && a.hash == b.hash &&
+		a.server == b.server && a.proto == b.proto &&
+		a.framing == b.framing && a.redirects == b.redirects
+}
+
+// noCacheProbe runs compareNoCache for the requests without and with
+// Via (req has Via set) if -no-cache=compare and -probe-rules allow it.
+// via is nil if the request with Via failed.
+func (s *site) noCacheProbe(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request, noVia response, via *response) {
+	if s.cfg.noCache != "compare" || !s.cfg.probeRules.allows("no-cache", s) ||
+		!s.probeStart("no-cache") {
+		return
+	}
+
+	s.setPhase("requesting without Via with no-cache")
+	noViaReq := req.Clone(req.Context())
+	noViaReq.Header.Del("Via")
+	s.noViaNoCacheChanged = s.compareNoCache(l, client, transport, noViaReq, noVia)
+
+	if via != nil {
+		s.setPhase("requesting with Via with no-cache")
+		s.viaNoCacheChanged = s.compareNoCache(l, client, transport, req, *via)
+	}
+	s.probeComplete("no-cache", 0, nil)
+}
@@ -0,0 +1,154 @@
+package viascan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// outputs are the formats accepted by -output: csv writes a line of
+// values separated by -delimiter per site, json an array of objects and ndjson
+// an object per line. Objects name every field (see fields).
+var outputs = map[string]bool{"csv": true, "json": true, "ndjson": true}
+
+// csvLine returns values as a line of CSV separated by delim, quoting
+// any value that contains delim or a quote (as some Server headers do)
+func csvLine(values []string, delim rune) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = delim
+	w.Write(values)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// csv returns s as a line of CSV separated by delim
+func (s *site) csv(delim rune) string {
+	values := s.values()
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = fmt.Sprint(v)
+	}
+	return csvLine(out, delim)
+}
+
+// parseDelimiter parses a -delimiter value: a single character or \t
+// for a tab
+func parseDelimiter(d string) (rune, error) {
+	if d == `\t` {
+		return '\t', nil
+	}
+	r := []rune(d)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' ||
+		r[0] == '#' {
+		return 0, fmt.Errorf("expecting a single character other than a quote, newline or #")
+	}
+	return r[0], nil
+}
+
+// MarshalJSON writes t as null if the test did not run or otherwise
+// true or false
+func (t tri) MarshalJSON() ([]byte, error) {
+	if !t.ran {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.yesno)
+}
+
+// json returns s as a JSON object with the fields in the same order
+// as the CSV output followed by any added by -post-process
+func (s *site) json() string {
+	names := strings.Split(s.fields(), ",")
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, v := range s.values() {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		name, _ := json.Marshal(names[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			value = []byte("null")
+		}
+		b.Write(name)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	for _, e := range s.extra {
+		name, _ := json.Marshal(e.name)
+		b.WriteString(",")
+		b.Write(name)
+		b.WriteString(":")
+		b.Write(e.value)
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
+
+// stream writes results to w in an -output format with the -fields
+// header and -trailer lines if they were asked for
+type stream struct {
+	w      io.Writer
+	output string
+	fields bool
+	delim  rune
+	t      *trailer
+	first  bool
+}
+
+func newStream(w io.Writer, output string, fields bool, delim rune,
+	chunk int) *stream {
+	o := &stream{w: w, output: output, fields: fields, delim: delim,
+		first: true}
+	if chunk > 0 {
+		o.t = newTrailer(chunk)
+	}
+	return o
+}
+
+func (o *stream) emit(line string) {
+	fmt.Fprintf(o.w, "%s\n", line)
+	if o.t != nil {
+		if tr := o.t.add(line); tr != "" {
+			fmt.Fprintf(o.w, "%s\n", tr)
+		}
+	}
+}
+
+// write writes s, starting the output first if it is the first result
+func (o *stream) write(s *site) {
+	switch o.output {
+	case "json":
+		if o.first {
+			fmt.Fprintf(o.w, "[")
+		} else {
+			fmt.Fprintf(o.w, ",")
+		}
+		fmt.Fprintf(o.w, "\n%s", s.json())
+	case "ndjson":
+		o.emit(s.json())
+	default:
+		if o.fields && o.first {
+			o.emit(csvLine(strings.Split(s.fields(), ","), o.delim))
+		}
+		o.emit(s.csv(o.delim))
+	}
+	o.first = false
+}
+
+// end finishes the output
+func (o *stream) end() {
+	if o.output == "json" {
+		if o.first {
+			fmt.Fprintf(o.w, "[")
+		}
+		fmt.Fprintf(o.w, "\n]\n")
+	}
+	if o.t != nil {
+		fmt.Fprintf(o.w, "%s\n", o.t.end(true))
+	}
+}
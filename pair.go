@@ -0,0 +1,110 @@
+package viascan
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readNames reads a file containing one name per line, skipping blank
+// lines and # comments and, if unique is set, removing duplicates.
+// Names that could not be used in a viascan input line are an error.
+func readNames(file string, unique bool) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	seen := make(map[string]bool)
+	scan := bufio.NewScanner(f)
+	line := 0
+	for scan.Scan() {
+		line++
+		name := strings.TrimSpace(scan.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if strings.ContainsAny(name, ", \t") {
+			return nil, fmt.Errorf("%s:%d: bad name %q", file, line, name)
+		}
+		name = strings.ToLower(name)
+		if unique && seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names, scan.Err()
+}
+
+// pairCommand implements the pair subcommand which writes viascan input
+// lines pairing every host with every origin (or, with -zip, the nth
+// host with the nth origin)
+func pairCommand(args []string) int {
+	fs := flag.NewFlagSet("pair", flag.ExitOnError)
+	zip := fs.Bool("zip", false,
+		"Pair hosts and origins line by line rather than every host with every origin")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: viascan pair [-zip] hosts.txt origins.txt\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	// With -zip the nth lines go together so duplicates are only removed
+	// once they are paired
+
+	hosts, err := readNames(fs.Arg(0), !*zip)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read hosts: %s\n", err)
+		return 1
+	}
+	origins, err := readNames(fs.Arg(1), !*zip)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read origins: %s\n", err)
+		return 1
+	}
+
+	if len(hosts) == 0 || len(origins) == 0 {
+		fmt.Fprintf(os.Stderr, "No hosts or no origins to pair\n")
+		return 1
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if *zip {
+		if len(hosts) != len(origins) {
+			fmt.Fprintf(os.Stderr,
+				"-zip needs the same number of hosts (%d) and origins (%d)\n",
+				len(hosts), len(origins))
+			return 1
+		}
+		seen := make(map[string]bool)
+		for i := range hosts {
+			line := hosts[i] + "," + origins[i]
+			if !seen[line] {
+				seen[line] = true
+				fmt.Fprintf(w, "%s\n", line)
+			}
+		}
+		return 0
+	}
+
+	for _, h := range hosts {
+		for _, o := range origins {
+			fmt.Fprintf(w, "%s,%s\n", h, o)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package viascan
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// phases are how long each part of a request took. Parts that did not
+// happen are zero: there is no DNS lookup for an origin given as an IP
+// address, no connect over QUIC, and neither connect nor TLS on a
+// pooled connection.
+type phases struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration // From sending the request to the first byte
+	total   time.Duration // From sending the request to reading the body
+}
+
+// String returns the phases in milliseconds separated by / in the
+// order dns/connect/tls/ttfb/total with - for those that did not happen
+func (p phases) String() string {
+	s := ""
+	for i, d := range []time.Duration{p.dns, p.connect, p.tls, p.ttfb,
+		p.total} {
+		if i > 0 {
+			s += "/"
+		}
+		if d == 0 {
+			s += "-"
+		} else {
+			s += ms(d)
+		}
+	}
+	return s
+}
+
+// phaseTimer times the phases of a request from the httptrace hooks,
+// which may be called on the goroutine dialing a connection after the
+// request itself has given up on it
+type phaseTimer struct {
+	sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	firstByte    time.Time
+	took         phases
+}
+
+func (t *phaseTimer) begin(at *time.Time) {
+	t.Lock()
+	*at = time.Now()
+	t.Unlock()
+}
+
+// end records the time since from in d unless it has already been
+// recorded. net/http reports a TLS handshake for connections made by a
+// custom TLS dialer once the dialer has returned, by when traceTLS has
+// already timed the real handshake.
+func (t *phaseTimer) end(from *time.Time, d *time.Duration) {
+	t.Lock()
+	if !from.IsZero() && *d == 0 {
+		*d = time.Since(*from)
+	}
+	t.Unlock()
+}
+
+// trace adds the hooks that time the phases to trace
+func (t *phaseTimer) trace(trace *httptrace.ClientTrace) {
+	trace.DNSStart = func(httptrace.DNSStartInfo) {
+		t.begin(&t.dnsStart)
+	}
+	trace.DNSDone = func(httptrace.DNSDoneInfo) {
+		t.end(&t.dnsStart, &t.took.dns)
+	}
+	trace.ConnectStart = func(network, addr string) {
+		t.begin(&t.connectStart)
+	}
+	trace.ConnectDone = func(network, addr string, err error) {
+		t.end(&t.connectStart, &t.took.connect)
+	}
+	trace.TLSHandshakeStart = func() {
+		t.begin(&t.tlsStart)
+	}
+	trace.TLSHandshakeDone = func(tls.ConnectionState, error) {
+		t.end(&t.tlsStart, &t.took.tls)
+	}
+	first := trace.GotFirstResponseByte
+	trace.GotFirstResponseByte = func() {
+		t.begin(&t.firstByte)
+		if first != nil {
+			first()
+		}
+	}
+}
+
+// phases returns the phases of a request that reached the milestones
+// in m. HTTP/3 does not report the first response byte so the time the
+// headers arrived stands in for it, and a request that failed before
+// its body was read ends when it failed.
+func (t *phaseTimer) phases(m milestones, ok bool) phases {
+	t.Lock()
+	defer t.Unlock()
+
+	p := t.took
+	first := t.firstByte
+	if first.IsZero() && ok {
+		first = m.headers
+	}
+	if !first.IsZero() {
+		p.ttfb = first.Sub(m.start)
+	}
+	end := m.done
+	if end.IsZero() {
+		end = m.headers
+	}
+	p.total = end.Sub(m.start)
+	return p
+}
+
+// traceDNS calls the DNS hooks in the trace in ctx, if any, around a
+// lookup of host done by lookup as the -resolver is not one that
+// httptrace knows about
+func traceDNS(ctx context.Context, host string,
+	lookup func() ([]net.IP, error)) ([]net.IP, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	ips, err := lookup()
+	if trace != nil && trace.DNSDone != nil {
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: ip}
+		}
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+	}
+	return ips, err
+}
+
+// traceTLS calls the TLS hooks in the trace in ctx, if any, around a
+// handshake done by handshake as custom TLS dialers do not get them
+// from net/http
+func traceTLS(ctx context.Context, handshake func() (tls.ConnectionState,
+	error)) error {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	state, err := handshake()
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// pmtuMSS is the MSS that -pmtu-check repeats stalled requests with.
+// It is the default for IPv4 so segments that small should get
+// through any path.
+const pmtuMSS = 536
+
+// stalled returns whether err means that a request stopped where the
+// origin sends full-size segments, in the TLS handshake (its
+// certificates) or while sending the body. That is what happens when
+// path MTU discovery fails because the ICMP messages it relies on are
+// blocked.
+func stalled(err error) bool {
+	switch failure("request", err) {
+	case "tls_timeout", "body_read_timeout":
+		return true
+	}
+	return false
+}
+
+// checkPMTU repeats a stalled request on a new connection with an MSS
+// of pmtuMSS and returns whether it then worked, which points to path
+// MTU discovery failing rather than the origin
+func (s *site) checkPMTU(l *os.File, req *http.Request) tri {
+	if s.proto == "h3" {
+		return tri{}
+	}
+	s.logf(l, "Request stalled, repeating with MSS %d", pmtuMSS)
+	transport := s.cfg.roundTripper(s.cfg.newTransport(s.proto), s.proto)
+	client := &http.Client{Transport: transport,
+		CheckRedirect: s.checkRedirect}
+	defer client.CloseIdleConnections()
+	_, err := s.fetchOnce(l, client, transport,
+		req.WithContext(withMSS(req.Context(), pmtuMSS)))
+	return tri{ran: true, yesno: err == nil}
+}
+
+// mss returns n or - if it is not known
+func mss(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,127 @@
+package viascan
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// workerPool is the workers of a running scan. Their number can be
+// changed while it runs (see resizeOnSignal and serve's /control): more
+// are started at once and surplus ones stop when they finish their
+// current site.
+type workerPool struct {
+	sync.Mutex
+	work    chan *site
+	result  chan *site
+	follow  *follower
+	counts  *siteCounts
+	log     *os.File
+	want    int            // Number of workers there should be
+	running int            // Number of workers there are
+	closed  bool           // Set once work is closed so no more are started
+	states  []*workerState // What each worker is doing (see dumpState)
+
+	wg sync.WaitGroup // Done when a worker stops
+}
+
+// newWorkerPool starts n workers taking sites from work
+func newWorkerPool(n int, work, result chan *site, follow *follower,
+	counts *siteCounts, l *os.File) *workerPool {
+	p := &workerPool{work: work, result: result, follow: follow,
+		counts: counts, log: l}
+	p.resize(n)
+	return p
+}
+
+// resize changes the number of workers to n
+func (p *workerPool) resize(n int) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.want = n
+	for !p.closed && p.running < p.want {
+		p.running++
+		state := &workerState{}
+		p.states = append(p.states, state)
+		p.wg.Add(1)
+		go worker(p, state)
+	}
+}
+
+// leave returns true if a worker should stop because there are more
+// workers than wanted
+func (p *workerPool) leave() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.running <= p.want {
+		return false
+	}
+	p.running--
+	return true
+}
+
+// close stops the workers once they have finished the sites queued and
+// waits for them
+func (p *workerPool) close() {
+	p.Lock()
+	p.closed = true
+	close(p.work)
+	p.Unlock()
+	p.wg.Wait()
+}
+
+// startWorkers starts the workers of a scan, whose number setWorkers
+// can then change
+func (pl *pipeline) startWorkers(work, result chan *site,
+	follow *follower) *workerPool {
+	pl.controls.Lock()
+	defer pl.controls.Unlock()
+
+	pl.pool = newWorkerPool(pl.workers, work, result, follow, &pl.counts,
+		pl.log)
+	return pl.pool
+}
+
+// setWorkers changes the number of workers of the scan running, if
+// there is one, and of those after it to n
+func (pl *pipeline) setWorkers(n int) {
+	pl.controls.Lock()
+	defer pl.controls.Unlock()
+
+	pl.workers = n
+	if pl.pool != nil {
+		pl.pool.resize(n)
+	}
+}
+
+// size returns the number of workers scans are run with
+func (pl *pipeline) size() int {
+	pl.controls.Lock()
+	defer pl.controls.Unlock()
+
+	return pl.workers
+}
+
+// resizeOnSignal adds a worker to the scans of pl every time SIGUSR1 is
+// received and removes one (keeping at least one) every time SIGUSR2
+// is, so that a scan can be slowed down or sped up without restarting
+// it
+func resizeOnSignal(pl *pipeline) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range c {
+			n := pl.size()
+			if sig == syscall.SIGUSR1 {
+				n++
+			} else if n > 1 {
+				n--
+			}
+			pl.setWorkers(n)
+			infof("Now running %d workers\n", n)
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package viascan
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// originHost returns the host of an origin without its port or the
+// brackets around an IPv6 address
+func originHost(origin string) string {
+	if h, _, err := net.SplitHostPort(origin); err == nil {
+		return h
+	}
+	return strings.Trim(origin, "[]")
+}
+
+// normalOrigin checks the port of an origin given in an input line and
+// returns it with brackets around an IPv6 address given without them
+// (so that the address is not taken for a host and port) and with port
+// added if it does not give one and port is not empty
+func normalOrigin(origin, port string) (string, error) {
+	if ip := net.ParseIP(origin); ip != nil && strings.Contains(origin, ":") {
+		origin = "[" + origin + "]"
+	}
+
+	host, p, err := net.SplitHostPort(origin)
+	if err != nil {
+		if strings.Contains(strings.Trim(origin, "[]"), ":") &&
+			!strings.HasPrefix(origin, "[") {
+			return "", fmt.Errorf("bad port in origin %s", origin)
+		}
+		if port == "" {
+			return origin, nil
+		}
+		return net.JoinHostPort(strings.Trim(origin, "[]"), port), nil
+	}
+
+	if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("bad port in origin %s", origin)
+	}
+	if host == "" {
+		return "", fmt.Errorf("empty host in origin %s", origin)
+	}
+	return origin, nil
+}
@@ -0,0 +1,46 @@
+package viascan
+
+import "testing"
+
+func TestNormalOrigin(t *testing.T) {
+	tests := []struct {
+		origin, port string
+		want         string
+		err          string
+	}{
+		{"example.com", "", "example.com", ""},
+		{"example.com", "8080", "example.com:8080", ""},
+		{"example.com:443", "8080", "example.com:443", ""},
+		{"192.0.2.1", "", "192.0.2.1", ""},
+		{"192.0.2.1", "8080", "192.0.2.1:8080", ""},
+		{"2001:db8::1", "", "[2001:db8::1]", ""},
+		{"2001:db8::1", "8080", "[2001:db8::1]:8080", ""},
+		{"[2001:db8::1]", "", "[2001:db8::1]", ""},
+		{"[2001:db8::1]", "8080", "[2001:db8::1]:8080", ""},
+		{"[2001:db8::1]:8443", "8080", "[2001:db8::1]:8443", ""},
+		{"example.com:1", "", "example.com:1", ""},
+		{"example.com:65535", "", "example.com:65535", ""},
+
+		{"example.com:0", "", "", "bad port in origin example.com:0"},
+		{"example.com:65536", "", "", "bad port in origin example.com:65536"},
+		{"example.com:http", "", "", "bad port in origin example.com:http"},
+		{"example.com:", "", "", "bad port in origin example.com:"},
+		{"example.com:80:80", "", "", "bad port in origin example.com:80:80"},
+		{":80", "", "", "empty host in origin :80"},
+	}
+
+	for _, tt := range tests {
+		got, err := normalOrigin(tt.origin, tt.port)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("normalOrigin(%q, %q): got error %v, want %s",
+					tt.origin, tt.port, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalOrigin(%q, %q) = %q, %v, want %q", tt.origin,
+				tt.port, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package viascan
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// masscanRecord is a host in masscan's JSON (-oJ) or NDJSON (-oD)
+// output, or in zmap's JSON output (saddr and sport)
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+
+	Saddr string `json:"saddr"`
+	Sport int    `json:"sport"`
+}
+
+// target is an open port found by a port scanner
+type target struct {
+	ip   string
+	port int
+}
+
+// origin returns the target as a viascan origin, leaving out the port
+// if it is the default port 80
+func (t target) origin() string {
+	if t.port == 80 {
+		return t.ip
+	}
+	return net.JoinHostPort(t.ip, strconv.Itoa(t.port))
+}
+
+// readTargets reads the open ports found by masscan or zmap. The
+// format is worked out from each line: JSON objects (masscan -oJ or
+// -oD, or zmap -O json), masscan's list format (-oL), zmap's CSV
+// output with a header line naming saddr and sport, or zmap's default
+// of one IP address per line, which are given port.
+func readTargets(r io.Reader, port int) ([]target, error) {
+	var targets []target
+	add := func(ip string, p int) error {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("bad IP address %q", ip)
+		}
+		targets = append(targets, target{ip: ip, port: p})
+		return nil
+	}
+
+	saddr, sport := -1, -1
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<20)
+	line := 0
+	for scan.Scan() {
+		line++
+
+		// masscan -oJ writes a JSON array with one host per line
+		// and commas at the start or end of lines
+
+		text := strings.Trim(strings.TrimSpace(scan.Text()), ",")
+		if text == "" || text == "[" || text == "]" ||
+			strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(text, "{"):
+			var m masscanRecord
+			if err = json.Unmarshal([]byte(text), &m); err != nil {
+				break
+			}
+			if m.Saddr != "" {
+				p := m.Sport
+				if p == 0 {
+					p = port
+				}
+				err = add(m.Saddr, p)
+				break
+			}
+			for _, p := range m.Ports {
+				if p.Proto == "tcp" && (p.Status == "" || p.Status == "open") {
+					if err = add(m.IP, p.Port); err != nil {
+						break
+					}
+				}
+			}
+
+		case strings.HasPrefix(text, "open "):
+			f := strings.Fields(text)
+			if len(f) < 4 || f[1] != "tcp" {
+				continue
+			}
+			var p int
+			if p, err = strconv.Atoi(f[2]); err == nil {
+				err = add(f[3], p)
+			}
+
+		case strings.Contains(text, ","):
+			f := strings.Split(text, ",")
+			if saddr == -1 {
+				for i, name := range f {
+					switch name {
+					case "saddr":
+						saddr = i
+					case "sport":
+						sport = i
+					}
+				}
+				if saddr == -1 {
+					err = fmt.Errorf("CSV header does not name saddr")
+				}
+				break
+			}
+			p := port
+			if sport != -1 && sport < len(f) {
+				if p, err = strconv.Atoi(f[sport]); err != nil {
+					break
+				}
+			}
+			if saddr < len(f) {
+				err = add(f[saddr], p)
+			}
+
+		default:
+			err = add(text, port)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	return targets, scan.Err()
+}
+
+// portscanCommand implements the portscan subcommand which turns the
+// output of masscan or zmap into viascan input lines, using each IP
+// address as the Host or pairing every target with every host in a
+// list
+func portscanCommand(args []string) int {
+	fs := flag.NewFlagSet("portscan", flag.ExitOnError)
+	port := fs.Int("port", 80,
+		"Port of targets in output that only lists IP addresses (zmap's default)")
+	hostFile := fs.String("hosts", "",
+		"File of hosts to pair with every target (default uses the IP address as the Host)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: viascan portscan [-hosts hosts.txt] [-port 80] [scan-output]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	in := os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", fs.Arg(0), err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	targets, err := readTargets(in, *port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read targets: %s\n", err)
+		return 1
+	}
+
+	var hosts []string
+	if *hostFile != "" {
+		if hosts, err = readNames(*hostFile, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read hosts: %s\n", err)
+			return 1
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		o := t.origin()
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+
+		if hosts == nil {
+			host := t.ip
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			fmt.Fprintf(w, "%s,%s\n", host, o)
+			continue
+		}
+		for _, h := range hosts {
+			fmt.Fprintf(w, "%s,%s\n", h, o)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,120 @@
+package viascan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// extraField is a field added to a result by the -post-process
+// command
+type extraField struct {
+	name  string
+	value json.RawMessage
+}
+
+// postProcessor is the -post-process command. Each result is written
+// to its stdin as a line of JSON and it answers each with a line
+// holding a JSON object whose fields are added to the result.
+type postProcessor struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	failed bool // Set once the command has stopped answering
+}
+
+func startPostProcessor(command string) (*postProcessor, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &postProcessor{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// process sends s to the command and adds the fields it answers with
+// to s. If the command stops answering the rest of the results are
+// written without any.
+func (p *postProcessor) process(s *site) {
+	if p == nil || p.failed {
+		return
+	}
+
+	if _, err := fmt.Fprintf(p.in, "%s\n", s.json()); err != nil {
+		p.fail(err)
+		return
+	}
+	line, err := p.out.ReadBytes('\n')
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	if s.extra, err = parseExtra(line, s.fields()); err != nil {
+		warnf("Bad answer from -post-process for %s: %s\n", s.origin, err)
+	}
+}
+
+func (p *postProcessor) fail(err error) {
+	warnf("-post-process stopped answering, writing results as they are: %s\n",
+		err)
+	p.failed = true
+}
+
+// close closes the command's stdin and waits for it to exit
+func (p *postProcessor) close() error {
+	if p == nil {
+		return nil
+	}
+	p.in.Close()
+	return p.cmd.Wait()
+}
+
+// parseExtra returns the fields of the JSON object in line in order,
+// leaving out any that have the name of one of fields (which are
+// comma-separated) so that a result's own fields are not replaced. An
+// empty line has no fields.
+func parseExtra(line []byte, fields string) ([]extraField, error) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, nil
+	}
+
+	own := make(map[string]bool)
+	for _, f := range strings.Split(fields, ",") {
+		own[f] = true
+	}
+
+	d := json.NewDecoder(bytes.NewReader(line))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("expecting a JSON object")
+	}
+	var extra []extraField
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := d.Decode(&value); err != nil {
+			return nil, err
+		}
+		if name := t.(string); !own[name] {
+			extra = append(extra, extraField{name: name, value: value})
+			own[name] = true
+		}
+	}
+	return extra, nil
+}
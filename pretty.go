@@ -0,0 +1,91 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	green  = "\033[32m"
+	yellow = "\033[33m"
+	red    = "\033[31m"
+	reset  = "\033[0m"
+	clear  = "\r\033[K"
+)
+
+// isTerminal returns true if f is a terminal rather than a pipe or
+// file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// pretty writes results for a person watching a terminal: one aligned
+// and colored line per site (green if the Via and no-Via responses are
+// identical, yellow if they differ, red if blocked or failed) and a
+// footer showing progress.
+type pretty struct {
+	counts    *siteCounts // Sites of the scan, for the total in the footer
+	done      int
+	identical int
+	different int
+	failed    int
+}
+
+// clip shortens s to at most n characters
+func clip(s string, n int) string {
+	if len(s) > n {
+		return s[:n-1] + "~"
+	}
+	return s
+}
+
+// header writes the column headings
+func (p *pretty) header() {
+	fmt.Printf("%-30s %-30s %-10s %17s %17s %s\n", "ORIGIN", "HOST", "VERDICT",
+		"SIZE", "ENCODING", "SERVER")
+}
+
+// write writes a line for s and redraws the footer
+func (p *pretty) write(s *site) {
+	p.done++
+
+	color := green
+	switch s.verdict() {
+	case "identical":
+		p.identical++
+	case "encoding", "size":
+		color = yellow
+		p.different++
+	default:
+		color = red
+		p.failed++
+	}
+
+	size := strconv.Itoa(s.noViaSize) + "/" + strconv.Itoa(s.viaSize)
+	encoding := s.noViaEncoding + "/" + s.viaEncoding
+	server := s.noViaServer
+	if s.viaServer != s.noViaServer {
+		server += "/" + s.viaServer
+	}
+
+	fmt.Printf("%s%s%-30s %-30s %-10s %17s %17s %s%s\n", clear, color,
+		clip(s.origin, 30), clip(s.host, 30), s.verdict(), clip(size, 17),
+		clip(encoding, 17), clip(server, 40), reset)
+	p.footer()
+}
+
+// footer draws the progress line at the bottom of the terminal
+func (p *pretty) footer() {
+	fmt.Printf("%s%d/%d sites  %s%d same%s  %s%d diff%s  %s%d failed%s",
+		clear, p.done, atomic.LoadInt64(&p.counts.queued), green, p.identical, reset,
+		yellow, p.different, reset, red, p.failed, reset)
+}
+
+// end leaves the footer in place as a final summary
+func (p *pretty) end() {
+	p.footer()
+	fmt.Printf("\n")
+}
@@ -0,0 +1,59 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// rejectsHeaders returns true if an origin answered a request in a way
+// that old servers that cannot cope with the headers of a modern
+// request do: closing the connection or answering 400 or 431. Failing
+// to connect or running out of time has nothing to do with headers.
+func rejectsHeaders(r response, err error) bool {
+	if err != nil {
+		return failure("request", err) == "response"
+	}
+	return r.status == http.StatusBadRequest ||
+		r.status == http.StatusRequestHeaderFieldsTooLarge
+}
+
+// minimalRequest returns req with only the Host and the headers in
+// headers. Go leaves out the User-Agent when it is set empty.
+func minimalRequest(req *http.Request, headers []string) *http.Request {
+	m := req.Clone(req.Context())
+	m.Header = http.Header{"User-Agent": {""}}
+	for _, h := range headers {
+		if v := req.Header.Values(h); len(v) > 0 {
+			m.Header[http.CanonicalHeaderKey(h)] = v
+		}
+	}
+	return m
+}
+
+// parseHeaderList parses a comma separated list of header names
+func parseHeaderList(list string) []string {
+	var names []string
+	for _, n := range strings.Split(list, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// retryMinimal repeats the request with no Via that an origin rejected
+// as a minimal request. If the origin accepts it the minimal request,
+// which the rest of the test then uses, and its response are returned.
+func (s *site) retryMinimal(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request) (*http.Request,
+	response, bool) {
+	s.logf(l, "Retrying with only %s",
+		strings.Join(append([]string{"Host"}, s.cfg.minimalHeaders...), ", "))
+	m := minimalRequest(req, s.cfg.minimalHeaders)
+	r, err := s.fetch(l, client, transport, m)
+	if rejectsHeaders(r, err) {
+		return nil, response{}, false
+	}
+	return m, r, true
+}
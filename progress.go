@@ -0,0 +1,69 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// siteCounts counts the sites of a scan so that progress can be shown
+type siteCounts struct {
+	queued    int64 // Sites handed to workers
+	completed int64 // Sites workers have finished testing
+	failed    int64 // Sites whose test failed (see failure)
+}
+
+// startProgress writes a line to stderr every interval (see -progress)
+// with the number of sites done out of those read so far, how many
+// failed and how many were done a second over the last interval. On a
+// terminal the line is redrawn in place. It returns a function that
+// writes the final line and stops.
+func startProgress(every time.Duration, c *siteCounts) func() {
+	if every <= 0 {
+		return func() {}
+	}
+
+	inPlace := isTerminal(os.Stderr)
+	start := time.Now()
+	last, lastDone := start, int64(0)
+	line := func(now time.Time, rate float64) {
+		done := atomic.LoadInt64(&c.completed)
+		prefix, suffix := "", "\n"
+		if inPlace {
+			prefix, suffix = clear, ""
+		}
+		fmt.Fprintf(os.Stderr, "%s%d/%d sites done, %d failed, %.1f sites/s%s",
+			prefix, done, atomic.LoadInt64(&c.queued),
+			atomic.LoadInt64(&c.failed), rate, suffix)
+		last, lastDone = now, done
+	}
+
+	quit := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				done := atomic.LoadInt64(&c.completed)
+				line(now, float64(done-lastDone)/now.Sub(last).Seconds())
+			case <-quit:
+				now := time.Now()
+				done := atomic.LoadInt64(&c.completed)
+				line(now, float64(done)/now.Sub(start).Seconds())
+				if inPlace {
+					fmt.Fprintf(os.Stderr, "\n")
+				}
+				close(finished)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-finished
+	}
+}
@@ -0,0 +1,237 @@
+package viascan
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxySchemes are the schemes of -proxy URLs. With socks5h the proxy
+// looks up the names of origins rather than viascan.
+var proxySchemes = map[string]bool{"http": true, "https": true,
+	"socks5": true, "socks5h": true}
+
+// errH3Proxy is returned when a request that would be sent over HTTP/3
+// is made with -proxy, as QUIC cannot be tunneled with CONNECT or
+// SOCKS5 CONNECT
+var errH3Proxy = errors.New("HTTP/3 cannot be sent through -proxy")
+
+// parseProxy parses a -proxy URL, which must be one of proxySchemes and
+// may give a user name and password
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !proxySchemes[u.Scheme] || u.Host == "" {
+		return nil, errors.New("expecting an http://, https://, socks5:// or socks5h:// URL")
+	}
+	return u, nil
+}
+
+// proxyAddr returns the host:port of the proxy
+func (cfg *config) proxyAddr() string {
+	if cfg.proxyURL.Port() != "" {
+		return cfg.proxyURL.Host
+	}
+	port := "80"
+	switch cfg.proxyURL.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(cfg.proxyURL.Hostname(), port)
+}
+
+// proxyDNS returns true if the -proxy looks up the names of origins
+func (cfg *config) proxyDNS() bool {
+	return cfg.proxyURL != nil && cfg.proxyURL.Scheme == "socks5h"
+}
+
+// proxyName returns the proxy without any user name or password (e.g.
+// http://proxy.example:3128), or empty if there is none
+func (cfg *config) proxyName() string {
+	if cfg.proxyURL == nil {
+		return ""
+	}
+	return cfg.proxyURL.Scheme + "://" + cfg.proxyURL.Host
+}
+
+// originAddr returns the IP address of the origin c is connected to,
+// which is the one asked of the -proxy if c goes through one, or empty
+// if the proxy looked the origin's name up
+func originAddr(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+	if t := timed(c); t != nil && t.target != "" {
+		addr = t.target
+	}
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(h) == nil {
+		return ""
+	}
+	return h
+}
+
+// bufferedConn is a connection whose first bytes were read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// connectProxy asks the proxy connected to on c to connect to address
+// and returns the connection that then reaches address. The exchange
+// with the proxy is part of connecting so it must be done within the
+// -connect-timeout.
+func (cfg *config) connectProxy(ctx context.Context, c net.Conn,
+	address string) (net.Conn, error) {
+	deadline, _ := ctx.Deadline()
+	if cfg.timeouts.connect > 0 {
+		if d := time.Now().Add(cfg.timeouts.connect); deadline.IsZero() ||
+			d.Before(deadline) {
+			deadline = d
+		}
+	}
+	c.SetDeadline(deadline)
+	defer c.SetDeadline(time.Time{})
+
+	if cfg.proxyURL.Scheme == "socks5" || cfg.proxyURL.Scheme == "socks5h" {
+		return c, cfg.connectSOCKS(c, address)
+	}
+
+	if cfg.proxyURL.Scheme == "https" {
+		t := tls.Client(c, &tls.Config{ServerName: cfg.proxyURL.Hostname()})
+		if err := t.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("TLS handshake with -proxy: %s", err)
+		}
+		c = t
+	}
+
+	req := &http.Request{Method: "CONNECT", URL: &url.URL{Opaque: address},
+		Host: address, Header: make(http.Header)}
+	if u := cfg.proxyURL.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("-proxy refused to connect to %s: %s", address,
+			resp.Status)
+	}
+
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: c, r: r}, nil
+	}
+	return c, nil
+}
+
+// socksReplies are the reasons a SOCKS5 proxy gives for not connecting
+var socksReplies = map[byte]string{1: "general failure",
+	2: "not allowed by ruleset", 3: "network unreachable",
+	4: "host unreachable", 5: "connection refused", 6: "TTL expired",
+	7: "command not supported", 8: "address type not supported"}
+
+// connectSOCKS asks the SOCKS5 proxy connected to on c to connect to
+// address (RFC 1928), logging in with the user name and password of the
+// -proxy if it has them (RFC 1929). address may be a name for the proxy
+// to look up.
+func (cfg *config) connectSOCKS(c net.Conn, address string) error {
+	method := byte(0)
+	if cfg.proxyURL.User != nil {
+		method = 2
+	}
+	if _, err := c.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return errors.New("-proxy did not accept the SOCKS5 authentication method")
+	}
+
+	if method == 2 {
+		user := cfg.proxyURL.User.Username()
+		password, _ := cfg.proxyURL.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("-proxy user name or password is too long")
+		}
+		login := append([]byte{1, byte(len(user))}, user...)
+		login = append(append(login, byte(len(password))), password...)
+		if _, err := c.Write(login); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("-proxy rejected the user name and password")
+		}
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	req := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	switch {
+	case ip.To4() != nil:
+		req = append(append(req, 1), ip.To4()...)
+	case ip != nil:
+		req = append(append(req, 4), ip...)
+	case len(host) > 255:
+		return fmt.Errorf("name too long for SOCKS5: %s", host)
+	default:
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = append(req, byte(p>>8), byte(p))
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+
+	// The reply ends with the address the proxy connected from, which is
+	// not needed but has to be read past
+
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		reason := socksReplies[head[1]]
+		if reason == "" {
+			reason = fmt.Sprintf("reply %d", head[1])
+		}
+		return fmt.Errorf("-proxy refused to connect to %s: %s", address, reason)
+	}
+	rest := map[byte]int{1: 4 - 1, 4: 16 - 1, 3: int(head[4])}[head[3]] + 2
+	_, err = io.ReadFull(c, make([]byte, rest))
+	return err
+}
@@ -0,0 +1,41 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+)
+
+// quarantine is the -quarantine file of input lines that were not
+// tested. Each line is the reason, a tab and the input line as it was
+// read (cut to -max-line-bytes if it was longer) so that the lines can
+// be fixed and scanned again.
+type quarantine struct {
+	f *os.File
+}
+
+func openQuarantine(file string) (*quarantine, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return &quarantine{f: f}, nil
+}
+
+// add writes line to the file with the reason it was rejected. Lines
+// are written unbuffered as they are rare and serve never closes the
+// file.
+func (q *quarantine) add(reason, line string) {
+	if q == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(q.f, "%s\t%s\n", reason, line); err != nil {
+		warnf("Failed to write -quarantine: %s\n", err)
+	}
+}
+
+func (q *quarantine) close() error {
+	if q == nil {
+		return nil
+	}
+	return q.f.Close()
+}
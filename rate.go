@@ -0,0 +1,139 @@
+package viascan
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket holding at most one token that fills at
+// rate tokens a second, so requests are spaced evenly with no bursts
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before using it.
+// Tokens can be taken before they have been added so that each caller
+// waiting is given its own later time.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = 1
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > 1 {
+			b.tokens = 1
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full returns true if the bucket has filled up by now, after which it
+// is the same as a new bucket
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= 1
+}
+
+// rateLimit limits the requests sent overall (-rate) and to each
+// origin (-per-host-rate) so that a scan of many sites on shared
+// infrastructure does not look like an attack on it
+type rateLimit struct {
+	sync.Mutex
+	overall *bucket
+	perHost float64
+	hosts   map[string]*bucket
+	swept   time.Time // When full buckets were last removed from hosts
+}
+
+// sweepEvery is how often buckets of origins that are no longer being
+// sent requests are removed
+const sweepEvery = time.Minute
+
+// newRateLimit creates a rateLimit allowing rate requests a second
+// overall and perHost to each origin (0 for no limit)
+func newRateLimit(rate, perHost float64) *rateLimit {
+	r := &rateLimit{perHost: perHost, hosts: make(map[string]*bucket)}
+	if rate > 0 {
+		r.overall = &bucket{rate: rate}
+	}
+	return r
+}
+
+// set changes the limits to rate requests a second overall and perHost
+// to each origin (0 for no limit)
+func (r *rateLimit) set(rate, perHost float64) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.overall = nil
+	if rate > 0 {
+		r.overall = &bucket{rate: rate}
+	}
+	if perHost != r.perHost {
+		r.perHost = perHost
+		r.hosts = make(map[string]*bucket)
+	}
+}
+
+// limits returns the limits overall and to each origin
+func (r *rateLimit) limits() (float64, float64) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.overall == nil {
+		return 0, r.perHost
+	}
+	return r.overall.rate, r.perHost
+}
+
+// wait blocks until a request may be sent to origin. The wait for the
+// origin comes first so that a request held back by -per-host-rate
+// does not take up a place in -rate while it waits.
+func (r *rateLimit) wait(origin string) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	var d time.Duration
+	if r.perHost > 0 {
+		now := time.Now()
+		b := r.hosts[origin]
+		if b == nil {
+			b = &bucket{rate: r.perHost}
+			r.hosts[origin] = b
+		}
+		d = b.reserve(now)
+		r.sweep(now)
+	}
+	r.Unlock()
+	time.Sleep(d)
+
+	r.Lock()
+	d = 0
+	if r.overall != nil {
+		d = r.overall.reserve(time.Now())
+	}
+	r.Unlock()
+	time.Sleep(d)
+}
+
+// sweep removes the buckets of origins that have filled up, at most
+// once every sweepEvery, so that hosts does not keep a bucket for
+// every origin ever scanned
+func (r *rateLimit) sweep(now time.Time) {
+	if now.Sub(r.swept) < sweepEvery {
+		return
+	}
+	r.swept = now
+	for origin, b := range r.hosts {
+		if b.full(now) {
+			delete(r.hosts, origin)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package viascan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &bucket{rate: 2}
+	steps := []struct {
+		after time.Duration
+		wait  time.Duration
+		full  bool // After the reservation
+	}{
+		{0, 0, false},
+		{0, 500 * time.Millisecond, false},
+		{250 * time.Millisecond, 750 * time.Millisecond, false},
+		{5 * time.Second, 0, false},
+	}
+	now := start
+	for i, s := range steps {
+		now = now.Add(s.after)
+		if got := b.reserve(now); got != s.wait {
+			t.Errorf("step %d: wait %s, want %s", i, got, s.wait)
+		}
+		if got := b.full(now); got != s.full {
+			t.Errorf("step %d: full is %t", i, got)
+		}
+	}
+	if !b.full(now.Add(500 * time.Millisecond)) {
+		t.Errorf("bucket not full after being idle")
+	}
+}
+
+// Buckets of origins that have not been sent anything for long enough
+// to fill up are removed
+func TestRateLimitSweep(t *testing.T) {
+	r := newRateLimit(0, 1)
+	now := time.Now()
+	idle := &bucket{rate: 1, last: now.Add(-2 * time.Second)}
+	r.hosts["idle.example.com"] = idle
+	r.hosts["busy.example.com"] = &bucket{rate: 1, tokens: -3, last: now}
+	r.sweep(now)
+	if len(r.hosts) != 1 || r.hosts["busy.example.com"] == nil {
+		t.Errorf("after sweeping got %v", r.hosts)
+	}
+
+	r.hosts["idle.example.com"] = idle
+	r.sweep(now.Add(time.Second))
+	if len(r.hosts) != 2 {
+		t.Errorf("swept again within %s", sweepEvery)
+	}
+}
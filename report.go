@@ -0,0 +1,187 @@
+package viascan
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// readResults reads every site in the results file name, keyed by
+// origin, host, probe and the address tested if there is one (so that
+// each row of -all-ips is kept), and the order in which they appeared
+func readResults(name string) (map[string]*site, []string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	sites := make(map[string]*site)
+	var order []string
+	rr := newResultReader(f)
+	for {
+		s, err := rr.next()
+		if err == io.EOF {
+			return sites, order, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", name, err)
+		}
+		k := s.origin + "," + s.host + "," + s.probe
+		if s.addr != "" {
+			k += "," + s.addr
+		}
+		if _, ok := sites[k]; !ok {
+			order = append(order, k)
+		}
+		sites[k] = s
+	}
+}
+
+// diffFields is the header for the lines written by diff
+const diffFields = "origin,host,probe,ip,before,after"
+
+// diffCommand implements the diff subcommand which compares two
+// results files and writes a line for every site whose verdict
+// changed, or that is only in one of them (its verdict in the other is
+// given as absent)
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan diff before.csv after.csv\n")
+		return 2
+	}
+
+	before, _, err := readResults(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %s\n", err)
+		return 1
+	}
+	after, order, err := readResults(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %s\n", err)
+		return 1
+	}
+
+	var changes []change
+	verdict := func(s *site) string {
+		if s == nil {
+			return "absent"
+		}
+		return s.verdict()
+	}
+	add := func(b, a *site) {
+		s := a
+		if s == nil {
+			s = b
+		}
+		if verdict(b) != verdict(a) {
+			changes = append(changes, change{origin: s.origin,
+				host: s.host, probe: s.probe, ip: s.addr, before: verdict(b),
+				after: verdict(a)})
+		}
+	}
+	for _, k := range order {
+		add(before[k], after[k])
+	}
+	var gone []string
+	for k := range before {
+		if after[k] == nil {
+			gone = append(gone, k)
+		}
+	}
+	sort.Strings(gone)
+	for _, k := range gone {
+		add(before[k], nil)
+	}
+
+	if len(changes) > 0 {
+		fmt.Printf("%s\n", diffFields)
+	}
+	for _, c := range changes {
+		fmt.Printf("%s\n", csvLine([]string{c.origin, c.host, c.probe, c.ip,
+			c.before, c.after}, ','))
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d sites changed\n", len(changes),
+		len(order)+len(gone))
+
+	return 0
+}
+
+// reportCommand implements the report subcommand which writes the
+// number and percentage of sites in a results file with each verdict,
+// or with -risk the sites at risk of cache poisoning
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	risk := fs.Bool("risk", false,
+		"List the sites with a cache poisoning risk, highest first, instead of counting verdicts")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan report [-risk] results.csv\n")
+		return 2
+	}
+
+	sites, order, err := readResults(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %s\n", err)
+		return 1
+	}
+	if *risk {
+		riskReport(sites, order)
+		return 0
+	}
+
+	counts := make(map[string]int)
+	var verdicts []string
+	for _, k := range order {
+		v := sites[k].verdict()
+		if counts[v] == 0 {
+			verdicts = append(verdicts, v)
+		}
+		counts[v]++
+	}
+	sort.Slice(verdicts, func(i, j int) bool {
+		if counts[verdicts[i]] != counts[verdicts[j]] {
+			return counts[verdicts[i]] > counts[verdicts[j]]
+		}
+		return verdicts[i] < verdicts[j]
+	})
+
+	fmt.Printf("%-30s %8s %7s\n", "VERDICT", "SITES", "%")
+	for _, v := range verdicts {
+		fmt.Printf("%-30s %8d %6.1f%%\n", v, counts[v],
+			100*float64(counts[v])/float64(len(order)))
+	}
+	fmt.Printf("%-30s %8d\n", "total", len(order))
+
+	return 0
+}
+
+// riskReport writes the sites with a risk above 0 with the highest
+// first, so that the origins most in need of fixing come at the top
+func riskReport(sites map[string]*site, order []string) {
+	var atRisk []*site
+	for _, k := range order {
+		if n, _ := strconv.Atoi(sites[k].risk); n > 0 {
+			atRisk = append(atRisk, sites[k])
+		}
+	}
+	score := func(s *site) int {
+		n, _ := strconv.Atoi(s.risk)
+		return n
+	}
+	sort.SliceStable(atRisk, func(i, j int) bool {
+		return score(atRisk[i]) > score(atRisk[j])
+	})
+
+	fmt.Printf("%4s %-30s %-30s %s\n", "RISK", "ORIGIN", "HOST", "FACTORS")
+	for _, s := range atRisk {
+		fmt.Printf("%4s %-30s %-30s %s\n", s.risk, s.origin, s.host,
+			s.riskFactors)
+	}
+	fmt.Printf("%d of %d sites at risk\n", len(atRisk), len(order))
+}
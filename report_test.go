@@ -0,0 +1,33 @@
+package viascan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The rows of -all-ips for the same origin, host and probe are kept
+// apart by the address each was tested at
+func TestReadResultsAllIPs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "results.csv")
+	err := os.WriteFile(file, []byte("origin,host,probe,ip,noVia,via\n"+
+		"example.com,example.com,,192.0.2.1,t,t\n"+
+		"example.com,example.com,,192.0.2.2,t,f\n"+
+		"example.org,example.org,,,t,t\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sites, order, err := readResults(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 3 || len(sites) != 3 {
+		t.Fatalf("got %d sites in order %q, want 3", len(sites), order)
+	}
+	for _, k := range order {
+		if s := sites[k]; s.addr == "192.0.2.2" && s.via.yesno {
+			t.Errorf("%s: the rows for each address were mixed up", k)
+		}
+	}
+}
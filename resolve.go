@@ -0,0 +1,63 @@
+package viascan
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// sharedResolver is shared by all workers. Concurrent lookups of the
+// same name are collapsed into a single query so that many workers
+// testing sites on one origin don't swamp the resolver.
+type sharedResolver struct {
+	resolver *dnsResolver
+	group    singleflight.Group
+
+	lookups int64 // Number of lookups requested
+	queries int64 // Number of queries actually sent to the resolver
+	shared  int64 // Number of lookups answered by another's query
+}
+
+func newSharedResolver(r *dnsResolver) *sharedResolver {
+	return &sharedResolver{resolver: r}
+}
+
+// lookupResult is what a lookup shared between workers produced
+type lookupResult struct {
+	ips []net.IP
+	msg *dns.Msg
+}
+
+// LookupHost resolves name, waiting for the result of any lookup of
+// the same name that is already in progress
+func (r *sharedResolver) LookupHost(name string) ([]net.IP, error) {
+	ips, _, err := r.Lookup(name)
+	return ips, err
+}
+
+// Lookup is LookupHost also returning the whole DNS response the
+// addresses came from (nil if there was none) for the -dump-dir
+// transcript. The response is shared with any other lookups of name
+// made at the same time.
+func (r *sharedResolver) Lookup(name string) ([]net.IP, *dns.Msg, error) {
+	atomic.AddInt64(&r.lookups, 1)
+	v, err, shared := r.group.Do(name, func() (interface{}, error) {
+		atomic.AddInt64(&r.queries, 1)
+		ips, msg, err := r.resolver.lookupHost(name)
+		return lookupResult{ips: ips, msg: msg}, err
+	})
+	if shared {
+		atomic.AddInt64(&r.shared, 1)
+	}
+	res := v.(lookupResult)
+	return res.ips, res.msg, err
+}
+
+// stats returns the number of lookups, queries sent and lookups that
+// shared another lookup's query
+func (r *sharedResolver) stats() (lookups, queries, shared int64) {
+	return atomic.LoadInt64(&r.lookups), atomic.LoadInt64(&r.queries),
+		atomic.LoadInt64(&r.shared)
+}
@@ -0,0 +1,211 @@
+package viascan
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolverScore is the result of benchmarking one resolver
+type resolverScore struct {
+	server     string
+	queries    int
+	failures   int
+	consistent int           // Answers that agreed with the majority
+	latency    time.Duration // Total time taken by successful queries
+	answers    map[string]string
+}
+
+func (r *resolverScore) failureRate() float64 {
+	return float64(r.failures) / float64(r.queries)
+}
+
+func (r *resolverScore) consistency() float64 {
+	ok := r.queries - r.failures
+	if ok == 0 {
+		return 0
+	}
+	return float64(r.consistent) / float64(ok)
+}
+
+func (r *resolverScore) meanLatency() time.Duration {
+	ok := r.queries - r.failures
+	if ok == 0 {
+		return 0
+	}
+	return r.latency / time.Duration(ok)
+}
+
+// answerKey turns a set of IPs into a string that is the same for the
+// same set in any order
+func answerKey(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+// readLines reads the non-blank, non-comment lines from a file
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		l := strings.TrimSpace(scan.Text())
+		if l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines, scan.Err()
+}
+
+// sampleOrigins reads viascan input lines from f and returns up to n
+// distinct origin names (IP addresses need no resolving)
+func sampleOrigins(f *os.File, n int) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	scan := bufio.NewScanner(f)
+	for scan.Scan() && len(names) < n {
+		parts := strings.Split(scan.Text(), ",")
+		if len(parts) != 2 {
+			continue
+		}
+		name := parts[1]
+		if h, _, err := net.SplitHostPort(name); err == nil {
+			name = h
+		}
+		if net.ParseIP(name) != nil || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, scan.Err()
+}
+
+// benchmark looks up every name with one resolver
+func benchmark(server string, names []string) *resolverScore {
+	r := &resolverScore{server: server, answers: make(map[string]string)}
+	res := newDNSResolver(server, 5*time.Second, 0)
+	for _, name := range names {
+		r.queries++
+		start := time.Now()
+		ips, err := res.LookupHost(name)
+		if err != nil {
+			r.failures++
+			continue
+		}
+		r.latency += time.Since(start)
+		r.answers[name] = answerKey(ips)
+	}
+	return r
+}
+
+// resolversCommand implements the resolvers subcommand which compares
+// candidate resolvers on a sample of the names that will be scanned
+func resolversCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "Usage: viascan resolvers test [-sample 100] [-best file] resolvers.txt < targets\n")
+		return 2
+	}
+	if len(args) < 1 || args[0] != "test" {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("resolvers test", flag.ExitOnError)
+	sample := fs.Int("sample", 100, "Number of origin names to look up")
+	best := fs.String("best", "", "File to write the recommended resolver to")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 || *sample < 1 {
+		return usage()
+	}
+
+	servers, err := readLines(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read resolvers: %s\n", err)
+		return 1
+	}
+	names, err := sampleOrigins(os.Stdin, *sample)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %s\n", err)
+		return 1
+	}
+	if len(servers) == 0 || len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "Need at least one resolver and one origin name\n")
+		return 1
+	}
+
+	scores := make([]*resolverScore, len(servers))
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(i int) {
+			scores[i] = benchmark(servers[i], names)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	// The majority answer for each name is taken to be the right one
+
+	for _, name := range names {
+		votes := make(map[string]int)
+		majority := ""
+		for _, r := range scores {
+			if a, ok := r.answers[name]; ok {
+				votes[a]++
+				if votes[a] > votes[majority] {
+					majority = a
+				}
+			}
+		}
+		for _, r := range scores {
+			if a, ok := r.answers[name]; ok && a == majority {
+				r.consistent++
+			}
+		}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		a, b := scores[i], scores[j]
+		if a.failureRate() != b.failureRate() {
+			return a.failureRate() < b.failureRate()
+		}
+		if a.consistency() != b.consistency() {
+			return a.consistency() > b.consistency()
+		}
+		return a.meanLatency() < b.meanLatency()
+	})
+
+	fmt.Printf("resolver,queries,failureRate,consistency,meanLatencyMs\n")
+	for _, r := range scores {
+		fmt.Printf("%s,%d,%.3f,%.3f,%.1f\n", r.server, r.queries,
+			r.failureRate(), r.consistency(),
+			float64(r.meanLatency())/float64(time.Millisecond))
+	}
+
+	fmt.Fprintf(os.Stderr, "Recommended: -resolver %s\n", scores[0].server)
+	if *best != "" {
+		err = ioutil.WriteFile(*best, []byte(scores[0].server+"\n"), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %s\n", *best, err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,166 @@
+package viascan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTri is the reverse of tri.String()
+func parseTri(v string) tri {
+	switch v {
+	case "t":
+		return tri{ran: true, yesno: true}
+	case "f":
+		return tri{ran: true}
+	}
+	return tri{}
+}
+
+// resultReader reads back the sites in viascan's output in any of the
+// -output formats, worked out from the first line. If csv output was
+// written with -fields the header line is used to find the fields,
+// otherwise the current field order is assumed, and the -delimiter
+// used is worked out too.
+type resultReader struct {
+	r      *csv.Reader
+	j      *json.Decoder // Set instead of r for json and ndjson output
+	fields map[string]int
+	line   int
+}
+
+func newResultReader(r io.Reader) *resultReader {
+	b := bufio.NewReader(r)
+	first, _ := b.Peek(4096)
+	if i := bytes.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+	if t := bytes.TrimSpace(first); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+		j := json.NewDecoder(b)
+		j.UseNumber()
+		if t[0] == '[' {
+			j.Token()
+		}
+		return &resultReader{j: j}
+	}
+
+	c := csv.NewReader(b)
+	c.Comma = sniffDelimiter(string(first))
+	c.FieldsPerRecord = -1
+	c.LazyQuotes = true
+	c.Comment = '#'
+
+	rr := &resultReader{r: c}
+	rr.setFields((&site{}).fields())
+	return rr
+}
+
+// sniffDelimiter returns the likeliest -delimiter for a line of
+// results: whichever of the usual ones appears most often in it
+func sniffDelimiter(line string) rune {
+	delim, most := ',', strings.Count(line, ",")
+	for _, d := range []rune{'\t', ';', '|'} {
+		if n := strings.Count(line, string(d)); n > most {
+			delim, most = d, n
+		}
+	}
+	return delim
+}
+
+func (rr *resultReader) setFields(header string) {
+	rr.fields = make(map[string]int)
+	for i, f := range strings.Split(header, ",") {
+		rr.fields[f] = i
+	}
+}
+
+// next returns the next site or io.EOF at the end of the results
+func (rr *resultReader) next() (*site, error) {
+	for {
+		var get func(name string) string
+		if rr.j != nil {
+			obj, err := rr.nextObject()
+			if err != nil {
+				return nil, err
+			}
+			get = func(name string) string {
+				v, ok := obj[name]
+				if !ok {
+					return ""
+				}
+				return jsonValue(v)
+			}
+		} else {
+			rec, err := rr.r.Read()
+			if err != nil {
+				return nil, err
+			}
+			rr.line++
+
+			if rr.line == 1 && len(rec) > 0 && rec[0] == "origin" {
+				rr.setFields(strings.Join(rec, ","))
+				continue
+			}
+
+			get = func(name string) string {
+				if i, ok := rr.fields[name]; ok && i < len(rec) {
+					return rec[i]
+				}
+				return ""
+			}
+		}
+		if get("origin") == "" {
+			return nil, fmt.Errorf("line %d: not a result", rr.line)
+		}
+
+		s := &site{origin: get("origin"), host: get("host"),
+			probe: get("probe"), resolves: parseTri(get("resolves")),
+			noVia: parseTri(get("noVia")), via: parseTri(get("via")),
+			noViaEncoding: get("noViaEncoding"),
+			viaEncoding:   get("viaEncoding"),
+			noViaServer:   get("noViaServer"), viaServer: get("viaServer"),
+			addr: get("ip"), risk: get("risk"),
+			riskFactors: get("riskFactors")}
+		s.noViaSize, _ = strconv.Atoi(get("noViaSize"))
+		s.viaSize, _ = strconv.Atoi(get("viaSize"))
+		s.noViaStatus, _ = strconv.Atoi(get("noViaStatus"))
+		s.viaStatus, _ = strconv.Atoi(get("viaStatus"))
+		s.bombSuspected = get("verdict") == "decompression_bomb_suspected"
+		s.allowlisted = get("verdict") == "allowlisted"
+
+		return s, nil
+	}
+}
+
+// nextObject returns the next result in json or ndjson output, or
+// io.EOF at the end of the results
+func (rr *resultReader) nextObject() (map[string]interface{}, error) {
+	if !rr.j.More() {
+		return nil, io.EOF
+	}
+	rr.line++
+	var obj map[string]interface{}
+	if err := rr.j.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("result %d: %s", rr.line, err)
+	}
+	return obj, nil
+}
+
+// jsonValue returns a value from a result in json or ndjson output as
+// it is written in csv output
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case bool:
+		return tri{ran: true, yesno: v}.String()
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,67 @@
+package viascan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// validator returns the value of If-Range that makes sure a resumed
+// body is the rest of the same representation: a strong ETag or,
+// failing that, Last-Modified
+func validator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" &&
+		!strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// readBody reads the body of resp. If reading it is interrupted and the
+// origin accepts byte ranges the rest of the body is requested from the
+// last byte received, up to resumes times. It returns the body and
+// whether it was resumed.
+func (s *site) readBody(l *os.File, client *http.Client, req *http.Request,
+	resp *http.Response) ([]byte, bool) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	resumed := false
+	for i := 0; err != nil && i < s.cfg.resumes; i++ {
+		s.logf(l, "Body interrupted after %d bytes: %s", len(body), err)
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			break
+		}
+
+		from := fmt.Sprintf("bytes %d-", len(body))
+		rest := req.Clone(req.Context())
+		rest.Header.Set("Range", "bytes="+from[6:])
+		if v := validator(resp); v != "" {
+			rest.Header.Set("If-Range", v)
+		}
+		s.cfg.rateLimits.wait(s.origin)
+		s.cfg.used.request(s.running)
+		restResp, restErr := client.Do(rest)
+		if restErr != nil {
+			s.logf(l, "Resuming from %d failed: %s", len(body), restErr)
+			break
+		}
+		if restResp.StatusCode != http.StatusPartialContent ||
+			!strings.HasPrefix(restResp.Header.Get("Content-Range"), from) {
+			s.logf(l, "Resuming from %d got %s, Content-Range %q", len(body),
+				restResp.Status, restResp.Header.Get("Content-Range"))
+			restResp.Body.Close()
+			break
+		}
+
+		var more []byte
+		more, err = ioutil.ReadAll(restResp.Body)
+		restResp.Body.Close()
+		body = append(body, more...)
+		resumed = true
+	}
+
+	return body, resumed
+}
@@ -0,0 +1,32 @@
+package viascan
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// transient returns true if err is a failure that may not happen if
+// the request is repeated: a reset connection, a timeout or the
+// connection closing before the response arrived
+func transient(err error) bool {
+	return isReset(err) || isTimeout(err) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetch performs a single HTTP request and summarizes the response,
+// repeating the request up to retries times if it fails transiently
+func (s *site) fetch(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request) (response, error) {
+	r, err := s.fetchOnce(l, client, transport, req)
+	wait := s.cfg.retryBackoff
+	for i := 0; i < s.cfg.retries && err != nil && transient(err); i++ {
+		s.logf(l, "Retrying in %s", wait)
+		time.Sleep(wait)
+		wait *= 2
+		r, err = s.fetchOnce(l, client, transport, req)
+	}
+	return r, err
+}
@@ -0,0 +1,81 @@
+package viascan
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cacheHeaders are response headers added by caches, any of which
+// shows that a cache sits between viascan and the origin
+var cacheHeaders = []string{"Age", "Cache-Status", "CF-Cache-Status",
+	"X-Cache", "X-Cache-Status", "X-Varnish"}
+
+// cacheHeader returns the name of the first of cacheHeaders in h or
+// empty if there are none
+func cacheHeader(h http.Header) string {
+	for _, name := range cacheHeaders {
+		if h.Get(name) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// riskWeights are the factors that make up a site's cache poisoning
+// risk and how much each adds to it. A response that differs with Via
+// is only a risk if a cache could store it and serve it to clients
+// that did not send Via, so the first three are differences and the
+// rest make it likelier that a cache will do so.
+var riskWeights = []struct {
+	name   string
+	weight int
+}{
+	{"encoding", 40},    // Content-Encoding differs with Via
+	{"status", 30},      // Status code differs with Via
+	{"size", 15},        // Body size differs with Via but not the encoding
+	{"no_vary_via", 20}, // Vary does not list Via so caches will not key on it
+	{"vary_differs", 5}, // Vary itself differs with Via
+	{"cacheable", 15},   // Response with Via is not marked no-store or private
+	{"cache_layer", 15}, // A cache answered or no-cache changed a response
+}
+
+// assessRisk sets the risk score of a site whose requests with and
+// without Via both worked, from 0 to 100, and the factors that
+// contributed to it joined by / (or none)
+func (s *site) assessRisk(noVia, via response) {
+	has := map[string]bool{
+		"encoding": noVia.encoding != via.encoding,
+		"status":   noVia.status != via.status,
+		"size": noVia.encoding == via.encoding &&
+			noVia.size != via.size,
+	}
+	if !has["encoding"] && !has["status"] && !has["size"] {
+		s.risk, s.riskFactors = "0", "none"
+		return
+	}
+
+	vary := "," + via.lists.get("Vary") + ","
+	has["no_vary_via"] = !strings.Contains(vary, ",via,") &&
+		!strings.Contains(vary, ",*,")
+	has["vary_differs"] = noVia.lists.get("Vary") != via.lists.get("Vary")
+	cc := "," + via.lists.get("Cache-Control") + ","
+	has["cacheable"] = !strings.Contains(cc, ",no-store,") &&
+		!strings.Contains(cc, ",private,")
+	has["cache_layer"] = noVia.cache != "" || via.cache != "" ||
+		s.noViaNoCacheChanged.yesno || s.viaNoCacheChanged.yesno
+
+	score := 0
+	var factors []string
+	for _, f := range riskWeights {
+		if has[f.name] {
+			score += f.weight
+			factors = append(factors, f.name)
+		}
+	}
+	if score > 100 {
+		score = 100
+	}
+	s.risk = strconv.Itoa(score)
+	s.riskFactors = strings.Join(factors, "/")
+}
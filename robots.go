@@ -0,0 +1,188 @@
+package viascan
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRobots is the most of a robots.txt that is read
+const maxRobots = 500 << 10
+
+// maxCrawlDelay is the longest Crawl-delay that is honored so that one
+// origin cannot stall a scan
+const maxCrawlDelay = time.Minute
+
+// robotsTimeout is the time allowed to fetch a robots.txt
+const robotsTimeout = 10 * time.Second
+
+// robotsCache holds the Crawl-delay of every host whose robots.txt has
+// been fetched (see -respect-robots). Each is fetched once however many
+// sites share it.
+type robotsCache struct {
+	sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+// robotsEntry is the Crawl-delay from one robots.txt, ready once it has
+// been fetched
+type robotsEntry struct {
+	ready chan struct{}
+	delay time.Duration
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{hosts: make(map[string]*robotsEntry)}
+}
+
+// honor fetches the robots.txt of the site's host from base, the
+// scheme and origin to send requests to, unless it already has been
+// and makes the Crawl-delay it asks for the least delay between
+// requests to the origin
+func (c *robotsCache) honor(l *os.File, s *site, transport http.RoundTripper,
+	base string) {
+	if c == nil {
+		return
+	}
+
+	key := base + "|" + s.host
+	c.Lock()
+	e := c.hosts[key]
+	fetch := e == nil
+	if fetch {
+		e = &robotsEntry{ready: make(chan struct{})}
+		c.hosts[key] = e
+	}
+	c.Unlock()
+
+	if fetch {
+		e.delay = s.fetchRobots(l, transport, base)
+		close(e.ready)
+	}
+	<-e.ready
+	s.cfg.backoffs.crawlDelay(s.origin, e.delay)
+}
+
+// fetchRobots fetches base/robots.txt from the site's host and returns
+// the Crawl-delay it asks of viascan or 0 if it does not or cannot be
+// fetched
+func (s *site) fetchRobots(l *os.File, transport http.RoundTripper,
+	base string) time.Duration {
+	req, err := http.NewRequest(http.MethodGet, base+"/robots.txt", nil)
+	if err != nil {
+		return 0
+	}
+	req.Host = s.host
+	if s.cfg.userAgent != "" {
+		req.Header.Set("User-Agent", s.cfg.userAgent)
+	}
+	ctx, cancel := withTimeout(s.withSNI(req.Context()), robotsTimeout)
+	defer cancel()
+
+	s.cfg.rateLimits.wait(s.origin)
+	s.cfg.backoffs.wait(s.origin)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		s.logf(l, "Failed to fetch robots.txt: %s", err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		s.logf(l, "No robots.txt: %s", resp.Status)
+		return 0
+	}
+
+	delay := crawlDelay(io.LimitReader(resp.Body, maxRobots),
+		robotsAgent(req.UserAgent()))
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+	if delay > 0 {
+		s.logf(l, "robots.txt asks for a Crawl-delay of %s", delay)
+	}
+	return delay
+}
+
+// robotsAgent returns the name robots.txt would give to the User-Agent
+// ua, its first product in lower case (e.g. go-http-client)
+func robotsAgent(ua string) string {
+	if ua == "" {
+		ua = "Go-http-client/1.1"
+	}
+	if i := strings.IndexAny(ua, "/ "); i >= 0 {
+		ua = ua[:i]
+	}
+	return strings.ToLower(ua)
+}
+
+// robotsGroup is the user agents a group of rules in a robots.txt
+// applies to and the Crawl-delay it gives
+type robotsGroup struct {
+	agents []string
+	delay  time.Duration
+}
+
+// crawlDelay returns the Crawl-delay in the robots.txt read from r for
+// the group that applies to agent: the one naming the longest part of
+// agent or, if none does, the one for *
+func crawlDelay(r io.Reader, agent string) time.Duration {
+	var groups []*robotsGroup
+	var g *robotsGroup
+	inAgents := false
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line := scan.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+		if field == "user-agent" {
+			if !inAgents {
+				g = &robotsGroup{}
+				groups = append(groups, g)
+				inAgents = true
+			}
+			g.agents = append(g.agents, strings.ToLower(value))
+			continue
+		}
+		inAgents = false
+		if field == "crawl-delay" && g != nil {
+			secs, err := strconv.ParseFloat(value, 64)
+			if err == nil && secs > 0 {
+				g.delay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	var delay time.Duration
+	longest := -1
+	for _, g := range groups {
+		for _, a := range g.agents {
+			match := 0
+			if a == "" {
+				continue
+			}
+			if a != "*" {
+				if !strings.HasPrefix(agent, a) {
+					continue
+				}
+				match = len(a)
+			}
+			if match > longest {
+				longest, delay = match, g.delay
+			}
+		}
+	}
+	return delay
+}
@@ -0,0 +1,147 @@
+package viascan
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// rollupKey identifies a count in a rollup
+type rollupKey struct {
+	probe string // Which request: no-via or via
+	stack string // Product in the Server header (see stack())
+	class string // Status class (2xx to 5xx) or error if the request failed
+}
+
+// rollup counts the status classes of the responses to the requests
+// with and without Via broken down by the stack that served them, so
+// that shifts across a whole scan (e.g. a CDN starting to return 403
+// to requests with Via) stand out
+type rollup struct {
+	sync.Mutex // Held while counting as -metrics-addr reads the counts
+	counts     map[rollupKey]int
+}
+
+func newRollup() *rollup {
+	return &rollup{counts: make(map[rollupKey]int)}
+}
+
+// stack returns the product named in a Server header, lowercased and
+// without its version (e.g. nginx for nginx/1.18.0 (Ubuntu)), or
+// unknown
+func stack(server string) string {
+	server = strings.ToLower(strings.TrimSpace(server))
+	if i := strings.IndexAny(server, "/ "); i >= 0 {
+		server = server[:i]
+	}
+	if server == "" {
+		return "unknown"
+	}
+	return server
+}
+
+// class returns the status class of a request that ran
+func class(worked tri, status int) string {
+	if !worked.yesno || status < 100 || status > 599 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// stack returns the stack that answered the request with no Via, or
+// the one with Via if that did not say, so that a stack that hides
+// itself when it sees Via is still counted as itself
+func (s *site) stack() string {
+	if s.noViaServer == "" {
+		return stack(s.viaServer)
+	}
+	return stack(s.noViaServer)
+}
+
+// record counts the requests made to s. Both requests are counted under
+// the stack that answered them (see site.stack).
+func (r *rollup) record(s *site) {
+	st := s.stack()
+
+	r.Lock()
+	defer r.Unlock()
+	if s.noVia.ran {
+		r.counts[rollupKey{"no-via", st, class(s.noVia, s.noViaStatus)}]++
+	}
+	if s.via.ran {
+		r.counts[rollupKey{"via", st, class(s.via, s.viaStatus)}]++
+	}
+}
+
+// keys returns the keys of the counts in order
+func (r *rollup) keys() []rollupKey {
+	var keys []rollupKey
+	for k := range r.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.probe != b.probe {
+			return a.probe < b.probe
+		}
+		if a.stack != b.stack {
+			return a.stack < b.stack
+		}
+		return a.class < b.class
+	})
+	return keys
+}
+
+// summary writes a line for each probe and stack giving the count of
+// each status class
+func (r *rollup) summary(w io.Writer) {
+	var probe, st string
+	for _, k := range r.keys() {
+		if k.probe != probe || k.stack != st {
+			if probe != "" {
+				fmt.Fprintf(w, "\n")
+			}
+			probe, st = k.probe, k.stack
+			fmt.Fprintf(w, "Status %s %s:", probe, st)
+		}
+		fmt.Fprintf(w, " %s %d", k.class, r.counts[k])
+	}
+	if probe != "" {
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// writeMetrics writes the counts to name in the Prometheus text format
+// (e.g. for the node_exporter textfile collector). The file is replaced
+// atomically so that a collector never reads half of it.
+func (r *rollup) writeMetrics(name string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".viascan-metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	r.prometheus(tmp)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// prometheus writes the counts to w in the Prometheus text format
+func (r *rollup) prometheus(w io.Writer) {
+	r.Lock()
+	defer r.Unlock()
+
+	fmt.Fprintf(w, "# HELP viascan_responses_total Responses by request, stack and status class.\n")
+	fmt.Fprintf(w, "# TYPE viascan_responses_total counter\n")
+	for _, k := range r.keys() {
+		fmt.Fprintf(w, "viascan_responses_total{probe=%q,stack=%q,class=%q} %d\n",
+			k.probe, k.stack, k.class, r.counts[k])
+	}
+}
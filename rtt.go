@@ -0,0 +1,75 @@
+package viascan
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// timedConn is a connection to an origin that remembers how long its
+// TCP handshake took. The handshake takes one round trip so it is an
+// estimate of how far away the server that answered is, and unlike the
+// connect phase it is known for requests that reused the connection.
+// It also counts the bytes sent and received (see usageCounts) and
+// records the segment size the connection sends with once it was made.
+type timedConn struct {
+	net.Conn
+	handshake time.Duration
+	mss       int
+	client    clientParams // How the client's side was set up (see -client-fingerprint)
+	target    string       // Address asked of the -proxy, if there is one
+	used      *usageCounts // Counts the bytes sent and received
+}
+
+func (c *timedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.used.received, int64(n))
+	return n, err
+}
+
+func (c *timedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.used.sent, int64(n))
+	return n, err
+}
+
+// timed returns the timedConn c, which may be wrapped in TLS, or nil
+// if it is not one
+func timed(c net.Conn) *timedConn {
+	for {
+		switch t := c.(type) {
+		case *timedConn:
+			return t
+		case interface{ NetConn() net.Conn }:
+			c = t.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// handshakeRTT returns the TCP handshake time of c, which may be
+// wrapped in TLS, or 0 if it is not known
+func handshakeRTT(c net.Conn) time.Duration {
+	if t := timed(c); t != nil {
+		return t.handshake
+	}
+	return 0
+}
+
+// connMSS returns the MSS of c, which may be wrapped in TLS, or 0 if
+// it is not known
+func connMSS(c net.Conn) int {
+	if t := timed(c); t != nil {
+		return t.mss
+	}
+	return 0
+}
+
+// rtt returns d in milliseconds or - if it is not known
+func rtt(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return ms(d)
+}
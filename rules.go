@@ -0,0 +1,163 @@
+package viascan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// condition is a test of the results of the requests with and without
+// Via that decides whether a rule applies to a site
+type condition struct {
+	key   string
+	value string
+	not   bool // Whether the condition was given as key!=value
+}
+
+// conditions maps the condition keys accepted by -probe-rules to a
+// function that returns the value of a site that is compared. Values
+// are lowercased and server matches any part of the Server header.
+var conditions = map[string]func(s *site) string{
+	"server":  func(s *site) string { return strings.ToLower(s.noViaServer) },
+	"stack":   func(s *site) string { return stack(s.noViaServer) },
+	"status":  func(s *site) string { return class(s.noVia, s.noViaStatus) },
+	"verdict": func(s *site) string { return s.verdict() },
+}
+
+func (c condition) matches(s *site) bool {
+	v := conditions[c.key](s)
+	if c.key == "server" {
+		return strings.Contains(v, c.value) != c.not
+	}
+	return (v == c.value) != c.not
+}
+
+// parseConditions parses a list of conditions of the form key=value or
+// key!=value separated by &
+func parseConditions(spec string) ([]condition, error) {
+	var when []condition
+	for _, c := range strings.Split(spec, "&") {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad condition %s: expecting key=value", c)
+		}
+		key := strings.TrimSpace(kv[0])
+		not := strings.HasSuffix(key, "!")
+		key = strings.TrimSpace(strings.TrimSuffix(key, "!"))
+		if _, ok := conditions[key]; !ok {
+			return nil, fmt.Errorf("unknown condition %s", key)
+		}
+		when = append(when, condition{key: key,
+			value: strings.ToLower(strings.TrimSpace(kv[1])), not: not})
+	}
+	return when, nil
+}
+
+// rule runs (or, if exclude is set, skips) a set of optional probes on
+// the sites that match all of its conditions
+type rule struct {
+	when    []condition
+	probes  []string
+	exclude bool
+}
+
+// ruleSet is the list of rules given with -probe-rules
+type ruleSet []rule
+
+// probeNames are the optional probes that rules can choose between
+var probeNames = map[string]bool{
+	"ae-matrix":  true,
+	"ae-order":   true,
+	"cdn-loop":   true,
+	"dictionary": true,
+	"echo":       true,
+	"forwarded":  true,
+	"no-cache":   true,
+	"trigger":    true,
+	"ua-compare": true,
+	"websocket":  true,
+}
+
+// parseRules parses a -probe-rules value of the form
+// "server=cloudflare:cdn-loop,ae-order;verdict=identical:!no-cache"
+// where each rule is a list of conditions separated by & (see
+// parseConditions) and the
+// probes to run on the sites that match them. A probe starting ! is
+// skipped on matching sites instead.
+func parseRules(spec string) (ruleSet, error) {
+	var rs ruleSet
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		wp := strings.SplitN(part, ":", 2)
+		if len(wp) != 2 {
+			return nil, fmt.Errorf("bad rule %s: expecting conditions:probes", part)
+		}
+
+		var r rule
+		var err error
+		if r.when, err = parseConditions(wp[0]); err != nil {
+			return nil, err
+		}
+
+		for i, p := range strings.Split(wp[1], ",") {
+			p = strings.TrimSpace(p)
+			exclude := strings.HasPrefix(p, "!")
+			p = strings.TrimPrefix(p, "!")
+			if !probeNames[p] {
+				return nil, fmt.Errorf("unknown probe %s", p)
+			}
+			if i > 0 && exclude != r.exclude {
+				return nil, fmt.Errorf("rule %s mixes running and skipping probes", part)
+			}
+			r.exclude = exclude
+			r.probes = append(r.probes, p)
+		}
+
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+func (r rule) matches(s *site) bool {
+	for _, c := range r.when {
+		if !c.matches(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r rule) names(probe string) bool {
+	for _, p := range r.probes {
+		if p == probe {
+			return true
+		}
+	}
+	return false
+}
+
+// allows returns true if the optional probe should be run on s. A
+// probe named by a rule that runs it only runs on sites that match
+// such a rule, and never on sites that match a rule that skips it.
+// Probes not named by any rule run everywhere.
+func (rs ruleSet) allows(probe string, s *site) bool {
+	included, restricted := false, false
+	for _, r := range rs {
+		if !r.names(probe) {
+			continue
+		}
+		if r.exclude {
+			if r.matches(s) {
+				return false
+			}
+			continue
+		}
+		restricted = true
+		included = included || r.matches(s)
+	}
+	return included || !restricted
+}
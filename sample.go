@@ -0,0 +1,89 @@
+package viascan
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// gzipSize asks for the last four bytes of a gzipped body, which hold
+// the size of the uncompressed data (ISIZE, modulo 2^32)
+func (s *site) gzipSize(client *http.Client, req *http.Request,
+	resp *http.Response) (int64, error) {
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, fmt.Errorf("ranges not supported")
+	}
+
+	tail := req.Clone(req.Context())
+	tail.Header.Set("Range", "bytes=-4")
+	if v := validator(resp); v != "" {
+		tail.Header.Set("If-Range", v)
+	}
+	s.cfg.rateLimits.wait(s.origin)
+	s.cfg.used.request(s.running)
+	tailResp, err := client.Do(tail)
+	if err != nil {
+		return 0, err
+	}
+	defer tailResp.Body.Close()
+	if tailResp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("range request got %s", tailResp.Status)
+	}
+
+	var isize [4]byte
+	if _, err := io.ReadFull(tailResp.Body, isize[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(isize[:])), nil
+}
+
+// inflated returns the number of bytes that the start of a gzipped body
+// decompresses to
+func (cfg *config) inflated(body []byte) int64 {
+	z, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	n, _ := io.Copy(ioutil.Discard, io.LimitReader(z, cfg.maxDecompressed))
+	return n
+}
+
+// sampleBody reads at most sampleBytes of the body of resp. If there
+// is more the size of the whole body is taken from Content-Length or,
+// for a gzipped body, estimated from its uncompressed size and the
+// compression ratio of the sample. It returns the sample, the size and
+// whether the body was cut short.
+func (s *site) sampleBody(l *os.File, client *http.Client, req *http.Request,
+	resp *http.Response) ([]byte, int, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.cfg.sampleBytes+1))
+	resp.Body.Close()
+	if err != nil {
+		s.logf(l, "Body interrupted after %d bytes: %s", len(body), err)
+	}
+	if int64(len(body)) <= s.cfg.sampleBytes {
+		return body, len(body), false
+	}
+	body = body[:s.cfg.sampleBytes]
+
+	if resp.ContentLength >= 0 {
+		return body, int(resp.ContentLength), true
+	}
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		total, err := s.gzipSize(client, req, resp)
+		if err != nil {
+			s.logf(l, "Cannot get gzip size: %s", err)
+		} else if out := s.cfg.inflated(body); out > 0 {
+			return body, int(total * int64(len(body)) / out), true
+		}
+	}
+
+	s.logf(l, "Size of sampled body unknown, at least %d bytes", len(body))
+	return body, len(body), true
+}
@@ -0,0 +1,185 @@
+// Package viascan tests web servers to see if they give different
+// results when an HTTP Via header is or is not present. It is what the
+// viascan command (in cmd/viascan) is built from, and Scanner lets
+// other programs run the same tests without running the command.
+package viascan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Target is a site to test: the Host header to send and the origin to
+// send it to. The origin is written as in an input line so it may have
+// a scheme, port and path and be a list of fallbacks separated by |.
+// IP, if set, is the address to connect to instead of looking the
+// origin up. In a Result the origin is the one tested, with its scheme
+// and path, and IP is the address its requests were sent to.
+type Target struct {
+	Host   string
+	Origin string
+	IP     string
+}
+
+// Response summarizes the response to one of the requests made to a
+// Target
+type Response struct {
+	Worked   bool   // Whether a response was received
+	Status   int    // HTTP status code
+	Size     int    // Size of the body
+	Encoding string // Content-Encoding with codings joined by +
+	Server   string // Server header
+	Proto    string // HTTP version (e.g. HTTP/1.1)
+}
+
+// Result is the outcome of testing a Target
+type Result struct {
+	Target  Target
+	Probe   string   // Expansion values applied (see -expand)
+	Verdict string   // Summary of the site's behavior, as in the verdict field
+	Failure string   // Why a request failed, if one did
+	NoVia   Response // Response with no Via header
+	Via     Response // Response with a Via header
+
+	s *site
+}
+
+// Fields returns the names of every field the viascan command writes
+// for a result in the order of Values
+func (r Result) Fields() []string {
+	return strings.Split(r.s.fields(), ",")
+}
+
+// Values returns the value of every field the viascan command writes
+// for the result. Fields that hold t or f are *bool and nil if their
+// test was not run.
+func (r Result) Values() []interface{} {
+	values := r.s.values()
+	for i, v := range values {
+		if t, ok := v.(tri); ok {
+			var b *bool
+			if t.ran {
+				b = &t.yesno
+			}
+			values[i] = b
+		}
+	}
+	return values
+}
+
+// CSV returns the result as the viascan command writes it with
+// -output=csv
+func (r Result) CSV() string {
+	return r.s.csv(',')
+}
+
+// JSON returns the result as the viascan command writes it with
+// -output=ndjson
+func (r Result) JSON() string {
+	return r.s.json()
+}
+
+// Scanner tests Targets as the viascan scan command does. Settings not
+// given here are those of the command's flags at their defaults. Each
+// Scan has its own copy of the settings so more than one can run at
+// once. Problems that do not stop a scan, such as a DNS server not
+// answering, are written to stderr as the command writes them.
+type Scanner struct {
+	Resolver string // DNS servers or DoH URLs to look up origins with, as -resolver (default 127.0.0.1)
+	Via      string // Via header value to send (default as -via)
+	HTTPS    bool   // Whether to connect with https unless an origin gives a scheme
+	Workers  int    // Number of sites tested at once (default 10)
+
+	// Time allowed for each whole request (0 for no limit other than
+	// the defaults of -connect-timeout, -tls-timeout and
+	// -response-header-timeout)
+	RequestTimeout time.Duration
+
+	// Hooks called as each site is tested
+	Hooks Hooks
+}
+
+// Scan tests the targets and calls result with the Result for each as
+// it is tested, from one goroutine but in no particular order. It
+// returns once every Target has been tested, or with an error and
+// without testing any if one of them is not valid.
+func (sc *Scanner) Scan(targets []Target, result func(Result)) error {
+	scheme := "http"
+	if sc.HTTPS {
+		scheme = "https"
+	}
+
+	var input strings.Builder
+	for _, t := range targets {
+		line := t.Host + "," + t.Origin
+		if t.IP != "" {
+			line += "," + t.IP
+		}
+		if strings.Contains(line, "\n") {
+			return fmt.Errorf("invalid target %q", line)
+		}
+		if _, err := parseLine(line, scheme, ""); err != nil {
+			return fmt.Errorf("invalid target %q: %s", line, err)
+		}
+		fmt.Fprintf(&input, "%s\n", line)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	pl := &pipeline{workers: sc.Workers, abortWindow: 1,
+		defaultScheme: scheme,
+		limits:        newGuard(0, 0, 250*time.Millisecond, stop),
+		roll:          newRollup(), cfg: sc.config()}
+	if pl.workers < 1 {
+		pl.workers = 10
+	}
+
+	// There is no -abort-on-error-rate so the scan is never aborted
+
+	_, err := pl.run(strings.NewReader(input.String()), resultSink(result))
+	return err
+}
+
+// config returns the Scanner's settings with the defaults of the scan
+// command's flags for the rest
+func (sc *Scanner) config() *config {
+	server := sc.Resolver
+	if server == "" {
+		server = "127.0.0.1"
+	}
+	cfg := newConfig()
+	res := newDNSResolvers(server, 5*time.Second, 2)
+	res.dialDoH(cfg.dial)
+	cfg.resolver = newSharedResolver(res)
+	if sc.Via != "" {
+		cfg.viaHeader = sc.Via
+	}
+	cfg.timeouts.request = sc.RequestTimeout
+	cfg.hooks = sc.Hooks
+	return cfg
+}
+
+// resultSink passes the results from the pipeline to a Scanner's
+// caller
+type resultSink func(Result)
+
+func (f resultSink) write(s *site) {
+	response := func(worked tri, status, size int, encoding, server,
+		proto string) Response {
+		return Response{Worked: worked.yesno, Status: status, Size: size,
+			Encoding: encoding, Server: server, Proto: proto}
+	}
+
+	origin := s.scheme + "://" + s.origin + s.path
+	f(Result{Target: Target{Host: s.host, Origin: origin, IP: s.addr},
+		Probe: s.probe, Verdict: s.verdict(), Failure: s.failure,
+		NoVia: response(s.noVia, s.noViaStatus, s.noViaSize,
+			s.noViaEncoding, s.noViaServer, s.noViaProto),
+		Via: response(s.via, s.viaStatus, s.viaSize, s.viaEncoding,
+			s.viaServer, s.viaProto),
+		s: s})
+}
+
+func (f resultSink) end() {
+}
@@ -0,0 +1,116 @@
+package viascan
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// resetServer listens on localhost and resets every connection made to
+// it, like an origin that only accepts an allowlist of addresses
+func resetServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*net.TCPConn).SetLinger(0)
+			c.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// The Via request made after the request with no Via was reset is a
+// probe like any other: it can be skipped and its completion is seen
+func TestScanResetHooks(t *testing.T) {
+	origin := resetServer(t)
+	for _, skipVia := range []bool{false, true} {
+		var mu sync.Mutex
+		var calls []string
+		sc := &Scanner{Workers: 1, Hooks: Hooks{
+			OnProbeStart: func(origin, host, probe string) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, "start "+probe)
+				return !(skipVia && probe == "via")
+			},
+			OnProbeComplete: func(origin, host, probe string, status int,
+				err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, fmt.Sprintf("complete %s %t", probe,
+					err != nil))
+			},
+		}}
+
+		var verdict string
+		err := sc.Scan([]Target{{Host: "example.com", Origin: origin}},
+			func(r Result) { verdict = r.Verdict })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"start no-via", "complete no-via true", "start via",
+			"complete via true"}
+		wantVerdict := "allowlisted"
+		if skipVia {
+			want, wantVerdict = want[:3], "failed"
+		}
+		if !reflect.DeepEqual(calls, want) || verdict != wantVerdict {
+			t.Errorf("skipping via %t: got %q and %s, want %q and %s", skipVia,
+				calls, verdict, want, wantVerdict)
+		}
+	}
+}
+
+// Scans running at once each get the results of their own targets and
+// leave the command's verbosity alone
+func TestScanConcurrent(t *testing.T) {
+	origin := resetServer(t)
+	before := verbosity
+
+	var wg sync.WaitGroup
+	got := make([]map[string]bool, 2)
+	for i := range got {
+		var targets []Target
+		for j := 0; j < 3+i*2; j++ {
+			targets = append(targets, Target{
+				Host: fmt.Sprintf("scan%d-%d.example.com", i, j), Origin: origin})
+		}
+		got[i] = make(map[string]bool)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sc := &Scanner{Workers: 2}
+			err := sc.Scan(targets, func(r Result) { got[i][r.Target.Host] = true })
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, hosts := range got {
+		if len(hosts) != 3+i*2 {
+			t.Errorf("scan %d got results for %v", i, hosts)
+		}
+		for host := range hosts {
+			if !strings.HasPrefix(host, fmt.Sprintf("scan%d-", i)) {
+				t.Errorf("scan %d got a result for %s", i, host)
+			}
+		}
+	}
+	if verbosity != before {
+		t.Errorf("verbosity changed from %d to %d", before, verbosity)
+	}
+}
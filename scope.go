@@ -0,0 +1,203 @@
+package viascan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// scopeSignature starts the last line of a signed scope file. It is
+// followed by the base64 ed25519 signature of everything before it.
+const scopeSignature = "#signature "
+
+// scopeList is the set of domains and networks that may be contacted
+// (see -scope). When it is set no connection is made to anything
+// outside it.
+type scopeList struct {
+	domains []string     // Names (and their subdomains) in scope
+	nets    []*net.IPNet // Networks in scope
+}
+
+// splitSigned separates a signed scope file into the signed content
+// and its signature
+func splitSigned(b []byte) ([]byte, []byte, error) {
+	b = bytes.TrimRight(b, "\n")
+	i := bytes.LastIndexByte(b, '\n')
+	last := b[i+1:]
+	if !bytes.HasPrefix(last, []byte(scopeSignature)) {
+		return nil, nil, errors.New("no signature line found")
+	}
+	sig, err := base64.StdEncoding.DecodeString(
+		string(bytes.TrimPrefix(last, []byte(scopeSignature))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad signature: %s", err)
+	}
+
+	return b[:i+1], sig, nil
+}
+
+// readKey reads a base64 encoded key from a file
+func readKey(file string, size int) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("bad key in %s: %s", file, err)
+	}
+	if len(k) != size {
+		return nil, fmt.Errorf("bad key in %s: wrong length", file)
+	}
+
+	return k, nil
+}
+
+// loadScope reads a scope file, checks its signature against the public
+// key in keyFile and parses it. Each line of the file is a domain name
+// or a CIDR; blank lines and # comments are ignored.
+func loadScope(file, keyFile string) (*scopeList, error) {
+	key, err := readKey(keyFile, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	content, sig, err := splitSigned(b)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), content, sig) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	sl := &scopeList{}
+	scan := bufio.NewScanner(bytes.NewReader(content))
+	for scan.Scan() {
+		entry := strings.ToLower(strings.TrimSpace(scan.Text()))
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			sl.nets = append(sl.nets, n)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			sl.nets = append(sl.nets, &net.IPNet{IP: ip,
+				Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		sl.domains = append(sl.domains, strings.TrimSuffix(entry, "."))
+	}
+	if len(sl.domains) == 0 && len(sl.nets) == 0 {
+		return nil, errors.New("scope is empty")
+	}
+
+	return sl, nil
+}
+
+// allowed returns true if name or any of ips is in scope. name may be
+// empty when only an IP is known.
+func (sl *scopeList) allowed(name string, ips []net.IP) bool {
+	if sl == nil {
+		return true
+	}
+
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, d := range sl.domains {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	for _, ip := range ips {
+		for _, n := range sl.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// scopeCommand implements the scope subcommand which creates keys and
+// signs scope files for use with -scope
+func scopeCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "Usage: viascan scope keygen name\n")
+		fmt.Fprintf(os.Stderr, "       viascan scope sign -key name.key scope.txt\n")
+		return 2
+	}
+	if len(args) < 1 {
+		return usage()
+	}
+
+	switch args[0] {
+	case "keygen":
+		if len(args) != 2 {
+			return usage()
+		}
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate key: %s\n", err)
+			return 1
+		}
+		for file, k := range map[string][]byte{args[1] + ".pub": pub,
+			args[1] + ".key": priv} {
+			err = ioutil.WriteFile(file,
+				[]byte(base64.StdEncoding.EncodeToString(k)+"\n"), 0600)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write %s: %s\n", file, err)
+				return 1
+			}
+		}
+		return 0
+
+	case "sign":
+		fs := flag.NewFlagSet("scope sign", flag.ExitOnError)
+		keyFile := fs.String("key", "", "File containing the private key")
+		fs.Parse(args[1:])
+		if *keyFile == "" || fs.NArg() != 1 {
+			return usage()
+		}
+		key, err := readKey(*keyFile, ed25519.PrivateKeySize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read key: %s\n", err)
+			return 1
+		}
+		b, err := ioutil.ReadFile(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read scope: %s\n", err)
+			return 1
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		sig := ed25519.Sign(ed25519.PrivateKey(key), b)
+		fmt.Printf("%s%s%s\n", b, scopeSignature,
+			base64.StdEncoding.EncodeToString(sig))
+		return 0
+	}
+
+	return usage()
+}
@@ -0,0 +1,93 @@
+package viascan
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+// selftestCommand implements the selftest subcommand which runs the
+// usual tests against local servers that behave in known ways and
+// checks that viascan reaches the expected verdict for each
+func selftestCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan selftest\n")
+		return 2
+	}
+
+	body := strings.Repeat("<p>The quick brown fox jumps over the lazy dog</p>\n", 100)
+	var zipped bytes.Buffer
+	z := gzip.NewWriter(&zipped)
+	z.Write([]byte(body))
+	z.Close()
+
+	// serve returns a handler that gzips the body when asked to
+	// unless gzipped returns false
+
+	serve := func(gzipped func(r *http.Request) bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Server", "selftest")
+			if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") &&
+				gzipped(r) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(zipped.Bytes())
+				return
+			}
+			w.Write([]byte(body))
+		})
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		verdict string
+	}{
+		{"ignores Via", serve(func(*http.Request) bool { return true }),
+			"identical"},
+		{"no gzip with Via", serve(func(r *http.Request) bool {
+			return r.Header.Get("Via") == ""
+		}), "encoding"},
+		{"blocks Via", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Via") != "" {
+				hj, _ := w.(http.Hijacker)
+				c, _, _ := hj.Hijack()
+				c.Close()
+				return
+			}
+			serve(func(*http.Request) bool { return true }).ServeHTTP(w, r)
+		}), "blocked"},
+		{"forbids everything", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}), "allowlisted"},
+	}
+
+	cfg := newConfig()
+	failed := 0
+	for _, t := range tests {
+		ts := httptest.NewServer(t.handler)
+		s := &site{cfg: cfg, host: "selftest.example",
+			origin: strings.TrimPrefix(ts.URL, "http://"),
+			scheme: "http", encoding: "gzip,deflate"}
+		s.test(nil)
+		ts.Close()
+
+		result := "ok"
+		if s.verdict() != t.verdict {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Printf("%-4s %-20s want %-12s got %s\n", result, t.name,
+			t.verdict, s.verdict())
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d tests failed\n", failed, len(tests))
+		return 1
+	}
+	return 0
+}
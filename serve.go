@@ -0,0 +1,452 @@
+package viascan
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxJobInput is the largest list of input lines serve accepts
+const maxJobInput = 64 << 20
+
+// maxQueuedJobs is how many jobs serve holds waiting for the one
+// running to finish before refusing more
+const maxQueuedJobs = 100
+
+// jobRetention is how long serve keeps a finished job's results
+const jobRetention = time.Hour
+
+// job is a scan submitted to serve. Its results are kept as NDJSON
+// lines so that any number of clients can read them while it runs.
+type job struct {
+	sync.Mutex
+	changed *sync.Cond // Broadcast when a result is added or the job ends
+
+	id    string
+	owner *apiKey // The key that submitted it, which alone can see it
+	input []byte
+	state string   // queued, running, done or aborted
+	lines []string // Results so far
+	err   string   // Why the job was aborted or its input was bad
+
+	submitted time.Time
+	started   time.Time
+	finished  time.Time
+}
+
+// jobStatus is the JSON returned for a job
+type jobStatus struct {
+	ID        string `json:"id"`
+	State     string `json:"state"`
+	Sites     int    `json:"sites"`
+	Error     string `json:"error,omitempty"`
+	Submitted string `json:"submitted"`
+	Started   string `json:"started,omitempty"`
+	Finished  string `json:"finished,omitempty"`
+	Results   string `json:"results"`
+}
+
+func newJob(id string, owner *apiKey, input []byte) *job {
+	j := &job{id: id, owner: owner, input: input, state: "queued",
+		submitted: time.Now()}
+	j.changed = sync.NewCond(&j.Mutex)
+	return j
+}
+
+// over returns true if the job has finished. j must be locked.
+func (j *job) over() bool {
+	return j.state == "done" || j.state == "aborted"
+}
+
+// status returns the job's status
+func (j *job) status() jobStatus {
+	j.Lock()
+	defer j.Unlock()
+
+	st := jobStatus{ID: j.id, State: j.state, Sites: len(j.lines),
+		Error: j.err, Submitted: j.submitted.UTC().Format(time.RFC3339),
+		Results: "/scan/" + j.id + "/results"}
+	if !j.started.IsZero() {
+		st.Started = j.started.UTC().Format(time.RFC3339)
+	}
+	if !j.finished.IsZero() {
+		st.Finished = j.finished.UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+// write adds the result for s, making job a sink for the pipeline
+func (j *job) write(s *site) {
+	line := s.json()
+	j.Lock()
+	j.lines = append(j.lines, line)
+	j.changed.Broadcast()
+	j.Unlock()
+}
+
+// end does nothing as the job ends when the pipeline returns
+func (j *job) end() {
+}
+
+// setState moves the job to state at the time given
+func (j *job) setState(state string, at *time.Time, err string) {
+	j.Lock()
+	j.state, *at, j.err = state, time.Now(), err
+	j.changed.Broadcast()
+	j.Unlock()
+}
+
+// server runs the jobs submitted to serve one at a time through the
+// pipeline with the flags viascan was started with
+type server struct {
+	sync.Mutex
+	pl    *pipeline
+	keys  []*apiKey
+	jobs  map[string]*job
+	queue chan *job
+}
+
+// serveCommand implements the serve subcommand which runs viascan as a
+// service, testing the sites on input lines POSTed to it with the same
+// flags as scan
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	build := testFlags(fs)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	keysFile := fs.String("keys", "",
+		"File of the API keys clients must send, one per line as: name key [sites an hour]")
+	control := fs.String("control", "",
+		"Names of the -keys (comma-separated) that may change the workers and rate at /control")
+	fs.Parse(args)
+
+	if *keysFile == "" {
+		errorf("serve requires -keys\n")
+		return 1
+	}
+	keys, err := readKeys(*keysFile)
+	if err != nil {
+		errorf("Bad -keys: %s\n", err)
+		return 1
+	}
+	for _, name := range strings.Split(*control, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		found := false
+		for _, k := range keys {
+			if k.name == name {
+				k.control, found = true, true
+			}
+		}
+		if !found {
+			errorf("Unknown -control key %s\n", name)
+			return 1
+		}
+	}
+
+	pl, status := build()
+	if status != 0 {
+		return status
+	}
+	defer pl.close()
+	if pl.cfg.rateLimits == nil {
+		pl.cfg.rateLimits = newRateLimit(0, 0)
+	}
+
+	dumpOnQuit(pl)
+	resizeOnSignal(pl)
+	err = pl.serve(*addr, keys)
+	errorf("Failed to serve on %s: %s\n", *addr, err)
+	return 1
+}
+
+// serve runs viascan as a service on addr until it fails. Input lines
+// are POSTed to /scan, which returns the new job's status, and the
+// status and NDJSON results of a job are at /scan/{id} and
+// /scan/{id}/results. The workers and rate are at /control.
+func (pl *pipeline) serve(addr string, keys []*apiKey) error {
+	sv := &server{pl: pl, keys: keys, jobs: make(map[string]*job),
+		queue: make(chan *job, maxQueuedJobs)}
+	go sv.runJobs()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scan", sv.submit)
+	mux.HandleFunc("/scan/", sv.get)
+	mux.HandleFunc("/control", sv.control)
+	infof("Serving on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// runJobs runs the queued jobs in turn
+func (sv *server) runJobs() {
+	for j := range sv.queue {
+		j.setState("running", &j.started, "")
+		reason, err := sv.pl.run(bytes.NewReader(j.input), j)
+		j.input = nil
+		switch {
+		case reason != "":
+			j.setState("aborted", &j.finished, reason)
+		case err != nil:
+			j.setState("aborted", &j.finished, err.Error())
+		default:
+			j.setState("done", &j.finished, "")
+		}
+
+		if err := sv.pl.store.flush(); err != nil {
+			warnf("Failed to write -output-sqlite: %s\n", err)
+		}
+		if err := sv.pl.cfg.dead.save(); err != nil {
+			warnf("Failed to write dead cache %s: %s\n", sv.pl.deadFile, err)
+		}
+
+		id := j.id
+		time.AfterFunc(jobRetention, func() {
+			sv.Lock()
+			delete(sv.jobs, id)
+			sv.Unlock()
+		})
+	}
+}
+
+// submit handles POST /scan with input lines as the body
+func (sv *server) submit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST input lines to /scan", http.StatusMethodNotAllowed)
+		return
+	}
+
+	k := sv.authorize(w, r)
+	if k == nil {
+		return
+	}
+
+	input, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJobInput))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if ok, wait := k.take(countSites(input), time.Now()); !ok {
+		if wait == 0 {
+			http.Error(w, "More sites than the key's quota",
+				http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j := newJob(hex.EncodeToString(id), k, input)
+
+	sv.Lock()
+	sv.jobs[j.id] = j
+	sv.Unlock()
+	select {
+	case sv.queue <- j:
+	default:
+		sv.Lock()
+		delete(sv.jobs, j.id)
+		sv.Unlock()
+		k.refund()
+		http.Error(w, "Too many jobs queued", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Location", "/scan/"+j.id)
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+// get handles GET /scan/{id} and /scan/{id}/results
+func (sv *server) get(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	k := sv.authorize(w, r)
+	if k == nil {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/scan/")
+	results := strings.HasSuffix(id, "/results")
+	id = strings.TrimSuffix(id, "/results")
+
+	sv.Lock()
+	j := sv.jobs[id]
+	sv.Unlock()
+	if j == nil || j.owner != k {
+		http.NotFound(w, r)
+		return
+	}
+
+	if results {
+		j.stream(w, r)
+	} else {
+		writeJSON(w, http.StatusOK, j.status())
+	}
+}
+
+// controlStatus is the JSON returned by /control
+type controlStatus struct {
+	Workers     int     `json:"workers"`
+	Rate        float64 `json:"rate"`
+	PerHostRate float64 `json:"perHostRate"`
+}
+
+// control handles GET /control, which returns the number of workers
+// and the rate limits, and POST /control, which changes those given as
+// form values (workers, rate and perHostRate) for the job running and
+// those after it
+func (sv *server) control(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST are allowed",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	k := sv.authorize(w, r)
+	if k == nil {
+		return
+	}
+	if !k.control {
+		http.Error(w, "The key may not use /control", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		workers, rate, perHost, err := sv.controls(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if workers != sv.pl.size() {
+			infof("%s set the workers to %d\n", k.name, workers)
+			sv.pl.setWorkers(workers)
+		}
+		limits := sv.pl.cfg.rateLimits
+		if oldRate, oldPerHost := limits.limits(); rate != oldRate ||
+			perHost != oldPerHost {
+			infof("%s set -rate to %g and -per-host-rate to %g\n", k.name,
+				rate, perHost)
+			limits.set(rate, perHost)
+		}
+	}
+
+	rate, perHost := sv.pl.cfg.rateLimits.limits()
+	writeJSON(w, http.StatusOK, controlStatus{Workers: sv.pl.size(),
+		Rate: rate, PerHostRate: perHost})
+}
+
+// controls returns the workers and rate limits POSTed to /control,
+// which are the current ones where they are not given
+func (sv *server) controls(r *http.Request) (int, float64, float64, error) {
+	workers := sv.pl.size()
+	rate, perHost := sv.pl.cfg.rateLimits.limits()
+	if err := r.ParseForm(); err != nil {
+		return 0, 0, 0, err
+	}
+	if v := r.PostForm.Get("workers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, 0, fmt.Errorf("workers must be a positive number")
+		}
+		workers = n
+	}
+	for name, f := range map[string]*float64{"rate": &rate,
+		"perHostRate": &perHost} {
+		if v := r.PostForm.Get(name); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x < 0 {
+				return 0, 0, 0, fmt.Errorf("%s must not be negative", name)
+			}
+			*f = x
+		}
+	}
+	return workers, rate, perHost, nil
+}
+
+// authorize returns the API key sent with r. If there is none it
+// answers 401 and returns nil.
+func (sv *server) authorize(w http.ResponseWriter, r *http.Request) *apiKey {
+	k := findKey(sv.keys, r)
+	if k == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="viascan"`)
+		http.Error(w, "Send an API key as Authorization: Bearer <key>",
+			http.StatusUnauthorized)
+	}
+	return k
+}
+
+// stream writes the job's results to w as they arrive until the job
+// ends or the client goes away
+func (j *job) stream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	// Wake the loop below if the client goes away while it waits
+
+	ctx := r.Context()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			j.Lock()
+			j.changed.Broadcast()
+			j.Unlock()
+		case <-done:
+		}
+	}()
+
+	sent := 0
+	for {
+		j.Lock()
+		for sent == len(j.lines) && !j.over() && ctx.Err() == nil {
+			j.changed.Wait()
+		}
+		lines, over := j.lines[sent:], j.over()
+		sent = len(j.lines)
+		j.Unlock()
+
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s\n", line)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if over || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// writeJSON writes v as the JSON body of a response with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,42 @@
+package viascan
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// shard is the part of the input this process is responsible for (see
+// -shard). Sites are assigned to shards by a hash of their origin so
+// that N processes given the same input test each origin once.
+type shard struct {
+	k, n uint32 // This is shard k (counting from 0) of n
+}
+
+// parseShard parses a -shard value of the form K/N
+func parseShard(spec string) (shard, error) {
+	var sh shard
+	if spec == "" {
+		return sh, nil
+	}
+
+	var extra string
+	n, _ := fmt.Sscanf(strings.Replace(spec, "/", " ", 1)+" ", "%d %d%s",
+		&sh.k, &sh.n, &extra)
+	if n != 2 || sh.n == 0 || sh.k >= sh.n {
+		return sh, fmt.Errorf("expecting K/N with 0 <= K < N")
+	}
+
+	return sh, nil
+}
+
+// mine returns true if origin belongs to this shard
+func (sh shard) mine(origin string) bool {
+	if sh.n == 0 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(origin)))
+	return h.Sum32()%sh.n == sh.k
+}
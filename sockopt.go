@@ -0,0 +1,108 @@
+package viascan
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// socketOptions are the socket options applied to every connection
+// made to an origin (see -tcp-nodelay, -tcp-keepalive and -dscp)
+type socketOptions struct {
+	noDelay   bool          // Whether to disable Nagle's algorithm
+	keepAlive time.Duration // Keepalive period (negative disables)
+	dscp      int           // DSCP value to mark packets with (0 leaves unmarked)
+	mss       int           // TCP maximum segment size (0 leaves the system's)
+}
+
+// mssKey is the context key for an MSS that overrides -tcp-mss for
+// the connections made for a request (see withMSS)
+type mssKey struct{}
+
+// withMSS returns ctx with connections made for it using mss
+func withMSS(ctx context.Context, mss int) context.Context {
+	return context.WithValue(ctx, mssKey{}, mss)
+}
+
+// dial connects to address applying the socket options, through the
+// -proxy if there is one
+func (cfg *config) dial(ctx context.Context, network,
+	address string) (net.Conn, error) {
+	target := address
+	if cfg.proxyURL != nil {
+		address = cfg.proxyAddr()
+	}
+
+	d := &net.Dialer{KeepAlive: cfg.sockOpts.keepAlive,
+		Timeout: cfg.timeouts.connect}
+	mss := cfg.sockOpts.mss
+	if m, ok := ctx.Value(mssKey{}).(int); ok {
+		mss = m
+	}
+	if cfg.sockOpts.dscp != 0 || mss != 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				if cfg.sockOpts.dscp != 0 {
+					serr = setDSCP(fd, network, cfg.sockOpts.dscp)
+				}
+				if serr == nil && mss != 0 {
+					serr = setMSS(fd, mss)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		}
+	}
+
+	// A -client-fingerprint port that is taken is given up for one the
+	// system chooses
+
+	p := cfg.clientFor(ctx)
+	if p.port != 0 {
+		d.LocalAddr = &net.TCPAddr{Port: p.port}
+	}
+	start := time.Now()
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil && p.port != 0 && portInUse(err) {
+		d.LocalAddr = nil
+		start = time.Now()
+		c, err = d.DialContext(ctx, network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	handshake := time.Since(start)
+	t := &timedConn{Conn: c, handshake: handshake, client: p,
+		used: cfg.used}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		tcp.SetNoDelay(cfg.sockOpts.noDelay)
+
+		// Closing with a reset rather than leaving the port in
+		// TIME_WAIT lets the next connection from it go to the same
+		// origin
+
+		if cfg.clientFingerprint == "constant" {
+			tcp.SetLinger(0)
+		}
+		if raw, err := tcp.SyscallConn(); err == nil {
+			raw.Control(func(fd uintptr) {
+				t.mss = getMSS(fd)
+			})
+		}
+	}
+	// Failing to get through the proxy is failing to connect
+
+	if cfg.proxyURL != nil {
+		t.target = target
+		if t.Conn, err = cfg.connectProxy(ctx, c, target); err != nil {
+			c.Close()
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,164 @@
+package viascan
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// sink is where the writer sends results: a stream to stdout or, with
+// -output-split-by, a splitter
+type sink interface {
+	write(s *site)
+	end()
+}
+
+// splitKeys are the dimensions accepted by -output-split-by and the
+// key each gives a site
+var splitKeys = map[string]func(s *site) string{
+	"asn":     func(s *site) string { return s.asn },
+	"tld":     func(s *site) string { return tld(s.origin) },
+	"verdict": (*site).verdict,
+}
+
+// splitter writes each result to a file in dir named for its key in
+// the -output-split-by dimension (e.g. blocked.csv) rather than to
+// stdout. Each file is a complete output of its own with the -fields
+// header and -trailer lines if they were asked for.
+type splitter struct {
+	key   func(s *site) string
+	dir   string
+	ext   string
+	open  func(w io.Writer) *stream
+	files map[string]*splitFile
+}
+
+// splitFile is the file for one key
+type splitFile struct {
+	f   *os.File
+	b   *bufio.Writer
+	out *stream
+}
+
+func newSplitter(by, dir, output string, fields bool, delim rune,
+	chunk int) (*splitter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &splitter{key: splitKeys[by], dir: dir, ext: output,
+		open: func(w io.Writer) *stream {
+			return newStream(w, output, fields, delim, chunk)
+		},
+		files: make(map[string]*splitFile)}, nil
+}
+
+// write writes s to the file for its key, creating it for the first
+// result with that key
+func (sp *splitter) write(s *site) {
+	key := fileKey(sp.key(s))
+	sf := sp.files[key]
+	if sf == nil {
+		name := filepath.Join(sp.dir, key+"."+sp.ext)
+		f, err := os.Create(name)
+		if err != nil {
+			warnf("Failed to create -output-split-by file: %s\n", err)
+			return
+		}
+		b := bufio.NewWriter(f)
+		sf = &splitFile{f: f, b: b, out: sp.open(b)}
+		sp.files[key] = sf
+	}
+	sf.out.write(s)
+}
+
+// end finishes and closes every file
+func (sp *splitter) end() {
+	for _, sf := range sp.files {
+		sf.out.end()
+		err := sf.b.Flush()
+		if cerr := sf.f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			warnf("Failed to write %s: %s\n", sf.f.Name(), err)
+		}
+	}
+}
+
+// fileKey returns key with anything that does not belong in a file
+// name replaced by _, or unknown if key is empty
+func fileKey(key string) string {
+	if key == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// tld returns the public suffix of origin (e.g. co.uk), or ip if it is
+// an IP address
+func tld(origin string) string {
+	host := origin
+	if h, _, err := net.SplitHostPort(origin); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return "ip"
+	}
+	suffix, _ := publicsuffix.PublicSuffix(strings.TrimSuffix(
+		strings.ToLower(host), "."))
+	return suffix
+}
+
+// asns caches the results of originASN by address
+var asns sync.Map
+
+// originASN returns the AS announcing ip (e.g. AS13335), or unknown,
+// using Team Cymru's IP to ASN mapping queried through the -resolver
+func (cfg *config) originASN(ip net.IP) string {
+	key := ip.String()
+	if asn, ok := asns.Load(key); ok {
+		return asn.(string)
+	}
+
+	asn := "unknown"
+	if rev, err := dns.ReverseAddr(key); err == nil {
+		name := strings.TrimSuffix(rev, "in-addr.arpa.") +
+			"origin.asn.cymru.com"
+		if ip.To4() == nil {
+			name = strings.TrimSuffix(rev, "ip6.arpa.") +
+				"origin6.asn.cymru.com"
+		}
+
+		// Answers look like "13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11"
+		// with more than one AS first if the prefix has several origins
+
+		if m, err := cfg.resolver.resolver.lookup(name, dns.TypeTXT); err == nil {
+			for _, rr := range m.Answer {
+				txt, ok := rr.(*dns.TXT)
+				if !ok || len(txt.Txt) == 0 {
+					continue
+				}
+				if f := strings.Fields(txt.Txt[0]); len(f) > 0 {
+					asn = "AS" + f[0]
+					break
+				}
+			}
+		}
+	}
+
+	asns.Store(key, asn)
+	return asn
+}
@@ -0,0 +1,193 @@
+package viascan
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// resultDB writes every result to a table in a SQLite database (see
+// -output-sqlite) so that repeated scans build up one database that
+// can be queried. Each scan gets a row in scans and each of its sites
+// a row in results with a column for every output field.
+type resultDB struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	insert string
+	scan   int64
+	rows   int
+}
+
+// resultSchema creates the tables written by -output-sqlite and viascan
+// history. The
+// results table gets a column for each output field (see fields) when
+// it is opened so that databases written by older versions gain the
+// new fields.
+const resultSchema = `
+CREATE TABLE IF NOT EXISTS scans (
+	id      INTEGER PRIMARY KEY,
+	started TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	scan INTEGER NOT NULL REFERENCES scans(id),
+	time TEXT NOT NULL
+);
+`
+
+// resultBatch is the number of results written in each transaction
+const resultBatch = 500
+
+// openResults opens (creating if necessary) a database of results in
+// file, as written by -output-sqlite and viascan history
+func openResults(file string) (*sql.DB, error) {
+	db, err := openSQLite(file, resultSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err = addColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS results_site
+		ON results (origin, host, probe, scan)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openResultDB opens (creating if necessary) the database in file and
+// records the start of a scan in it
+func openResultDB(file string, started time.Time) (*resultDB, error) {
+	db, err := openResults(file)
+	if err != nil {
+		return nil, err
+	}
+	r := &resultDB{db: db}
+	res, err := db.Exec(`INSERT INTO scans (started) VALUES (?)`,
+		started.UTC().Format(time.RFC3339))
+	if err == nil {
+		r.scan, err = res.LastInsertId()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	names := strings.Split((&site{}).fields(), ",")
+	r.insert = `INSERT INTO results (scan, time, "` +
+		strings.Join(names, `", "`) + `") VALUES (?, ?` +
+		strings.Repeat(", ?", len(names)) + `)`
+	return r, nil
+}
+
+// addColumns adds a column to results for every output field it does
+// not already have, typed from the field's Go type: INTEGER for numbers
+// and t/f fields (NULL for -) and TEXT for the rest
+func addColumns(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('results')`)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	empty := &site{cfg: newConfig()}
+	values := empty.values()
+	for i, name := range strings.Split(empty.fields(), ",") {
+		if have[name] {
+			continue
+		}
+		kind := "TEXT"
+		switch values[i].(type) {
+		case int, tri:
+			kind = "INTEGER"
+		}
+		_, err := db.Exec(fmt.Sprintf(`ALTER TABLE results ADD COLUMN "%s" %s`,
+			name, kind))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write adds a row for s, committing every resultBatch rows
+func (r *resultDB) write(s *site) error {
+	if r == nil {
+		return nil
+	}
+
+	if r.tx == nil {
+		var err error
+		if r.tx, err = r.db.Begin(); err != nil {
+			return err
+		}
+	}
+
+	args := []interface{}{r.scan, time.Now().UTC().Format(time.RFC3339)}
+	for _, v := range s.values() {
+		switch v := v.(type) {
+		case int, string:
+			args = append(args, v)
+		case tri:
+			if v.ran {
+				args = append(args, v.yesno)
+			} else {
+				args = append(args, nil)
+			}
+		default:
+			args = append(args, fmt.Sprint(v))
+		}
+	}
+	if _, err := r.tx.Exec(r.insert, args...); err != nil {
+		return err
+	}
+
+	r.rows++
+	if r.rows%resultBatch == 0 {
+		err := r.tx.Commit()
+		r.tx = nil
+		return err
+	}
+	return nil
+}
+
+// flush commits any results not yet committed
+func (r *resultDB) flush() error {
+	if r == nil || r.tx == nil {
+		return nil
+	}
+
+	err := r.tx.Commit()
+	r.tx = nil
+	return err
+}
+
+// close commits any results not yet committed and closes the database
+func (r *resultDB) close() error {
+	if r == nil {
+		return nil
+	}
+
+	var err error
+	if r.tx != nil {
+		err = r.tx.Commit()
+	}
+	if cerr := r.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package viascan
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// workerState is what a worker is currently doing, reported on SIGQUIT
+type workerState struct {
+	sync.Mutex
+
+	target string    // Site being tested
+	phase  string    // Part of the test in progress
+	since  time.Time // When the phase started
+}
+
+// removeState forgets the state of a worker of p that has stopped
+func (p *workerPool) removeState(state *workerState) {
+	p.Lock()
+	defer p.Unlock()
+
+	for i, ws := range p.states {
+		if ws == state {
+			p.states = append(p.states[:i], p.states[i+1:]...)
+			return
+		}
+	}
+}
+
+// set records that a worker has moved on to a new phase
+func (w *workerState) set(target, phase string) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	w.target, w.phase, w.since = target, phase, time.Now()
+	w.Unlock()
+}
+
+// setPhase records the phase of testing that s has reached
+func (s *site) setPhase(phase string) {
+	s.state.set(s.host+","+s.origin, phase)
+	s.dumpf("%s", phase)
+}
+
+// dumpState writes the internal state of the scans of pl to w
+func (pl *pipeline) dumpState(w io.Writer) {
+	q := atomic.LoadInt64(&pl.counts.queued)
+	c := atomic.LoadInt64(&pl.counts.completed)
+	fmt.Fprintf(w, "viascan state at %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(w, "sites queued: %d, completed: %d, in progress: %d\n", q, c,
+		q-c)
+	pl.controls.Lock()
+	p := pl.pool
+	pl.controls.Unlock()
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	for i, ws := range p.states {
+		ws.Lock()
+		if ws.phase == "" {
+			fmt.Fprintf(w, "worker %d: idle\n", i)
+		} else {
+			fmt.Fprintf(w, "worker %d: %s %s for %s\n", i, ws.target, ws.phase,
+				time.Since(ws.since).Round(time.Millisecond))
+		}
+		ws.Unlock()
+	}
+}
+
+// dumpOnQuit writes the internal state of pl to stderr every time
+// SIGQUIT is received rather than terminating, so that stuck scans can
+// be debugged
+func dumpOnQuit(pl *pipeline) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGQUIT)
+	go func() {
+		for range c {
+			pl.dumpState(os.Stderr)
+		}
+	}()
+}
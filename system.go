@@ -0,0 +1,77 @@
+package viascan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// systemResolver is the -resolver that looks names up with the
+// system's resolver, for machines with no DNS server of their own
+const systemResolver = "system"
+
+// exchangeSystem answers a query with the system's resolver. The
+// answer is made into a DNS response so that it is used like any
+// other, but only A, AAAA and TXT queries can be answered and the TTLs are
+// not known.
+func (r *dnsResolver) exchangeSystem(m *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	// The name is given without the final . as that stops the system
+	// looking it up in /etc/hosts
+
+	q := m.Question[0]
+	name := strings.TrimSuffix(q.Name, ".")
+	in := new(dns.Msg)
+	in.SetReply(m)
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET}
+
+	var err error
+	switch q.Qtype {
+	case dns.TypeA:
+		var ips []net.IP
+		ips, err = net.DefaultResolver.LookupIP(ctx, "ip4", name)
+		for _, ip := range ips {
+			in.Answer = append(in.Answer, &dns.A{Hdr: hdr, A: ip})
+		}
+	case dns.TypeAAAA:
+		var ips []net.IP
+		ips, err = net.DefaultResolver.LookupIP(ctx, "ip6", name)
+		for _, ip := range ips {
+			in.Answer = append(in.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	case dns.TypeTXT:
+		var txts []string
+		txts, err = net.DefaultResolver.LookupTXT(ctx, name)
+		for _, txt := range txts {
+			in.Answer = append(in.Answer, &dns.TXT{Hdr: hdr, Txt: []string{txt}})
+		}
+	default:
+		return nil, fmt.Errorf("the system resolver cannot look up %s records",
+			dns.TypeToString[q.Qtype])
+	}
+
+	// A name with no records of the type asked for but with addresses
+	// exists, so it is answered with no records rather than NXDOMAIN
+	// (e.g. an A query for a name that only has AAAA records)
+
+	var dnsErr *net.DNSError
+	var addrErr *net.AddrError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound ||
+		errors.As(err, &addrErr) {
+		ips, _ := net.DefaultResolver.LookupIP(ctx, "ip", name)
+		if len(ips) == 0 {
+			in.Rcode = dns.RcodeNameError
+		}
+		return in, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
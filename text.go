@@ -0,0 +1,151 @@
+package viascan
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// errBomb is returned when a body decompresses to more than the limits
+var errBomb = errors.New("decompression bomb suspected")
+
+// errNotText is returned for a body that is not HTML or text or can't
+// be decoded
+var errNotText = errors.New("not text")
+
+// bombReader reads decompressed data and fails with errBomb once more
+// than max bytes have been read
+type bombReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (b *bombReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > b.max {
+		return n, errBomb
+	}
+	return n, err
+}
+
+// decode undoes the Content-Encoding of a body, undoing each coding
+// listed in reverse order. It returns false if a coding is not
+// understood.
+func (cfg *config) decode(body []byte, encoding string) (io.Reader, bool) {
+	var r io.Reader = bytes.NewReader(body)
+	list := codings(encoding)
+	for i := len(list) - 1; i >= 0; i-- {
+		switch list[i] {
+		case "identity":
+		case "gzip", "x-gzip":
+			g, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, false
+			}
+			r = g
+		case "deflate":
+			r = flate.NewReader(r)
+		default:
+			return nil, false
+		}
+	}
+
+	max := cfg.maxRatio * int64(len(body))
+	if max > cfg.maxDecompressed || max <= 0 {
+		max = cfg.maxDecompressed
+	}
+	return &bombReader{r: r, max: max}, true
+}
+
+// skipText is the set of HTML elements whose content is not visible
+var skipText = map[string]bool{"script": true, "style": true,
+	"noscript": true, "template": true}
+
+// visibleText extracts the text a person would see from an HTML or
+// plain text body, converted to UTF-8 with whitespace collapsed, so
+// that bodies can be compared while ignoring changes in markup. It
+// returns errNotText if the body is not text or cannot be decoded and
+// errBomb if it decompresses to too much.
+func (cfg *config) visibleText(body []byte, contentType,
+	encoding string) (string, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mt != "text/html" && mt != "text/plain" &&
+		mt != "application/xhtml+xml") {
+		return "", errNotText
+	}
+
+	r, ok := cfg.decode(body, encoding)
+	if !ok {
+		return "", errNotText
+	}
+	r, err = charset.NewReader(r, contentType)
+	if err != nil {
+		return "", textErr(err)
+	}
+
+	if mt == "text/plain" {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", textErr(err)
+		}
+		return strings.Join(strings.Fields(string(b)), " "), nil
+	}
+
+	var words []string
+	skip := 0
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return "", textErr(z.Err())
+			}
+			return strings.Join(words, " "), nil
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if skipText[string(name)] {
+				skip++
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if skipText[string(name)] && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				words = append(words, strings.Fields(string(z.Text()))...)
+			}
+		}
+	}
+}
+
+// textErr returns errBomb if err was caused by too much decompressed
+// data and errNotText otherwise
+func textErr(err error) error {
+	if errors.Is(err, errBomb) {
+		return errBomb
+	}
+	return errNotText
+}
+
+// textSum returns the checksum of a body's visible text or an error
+// from visibleText
+func (cfg *config) textSum(body []byte, contentType,
+	encoding string) ([32]byte, error) {
+	t, err := cfg.visibleText(body, contentType, encoding)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256([]byte(t)), nil
+}
@@ -0,0 +1,80 @@
+package viascan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// timeoutSettings are the time allowed for each phase of a request (0
+// for no limit). DNS queries have their own timeout (see
+// newDNSResolver).
+type timeoutSettings struct {
+	connect        time.Duration // Making the TCP connection
+	tls            time.Duration // The TLS handshake
+	responseHeader time.Duration // From sending the request to the response headers
+	bodyRead       time.Duration // Reading the whole body
+	request        time.Duration // The whole request from connecting to reading the body
+}
+
+// errBodyTimeout is returned by fetch when the body was not read within
+// the -body-read-timeout
+var errBodyTimeout = errors.New("timed out reading body")
+
+// errRequestTimeout is returned by fetch when the whole request was not
+// done within the -request-timeout
+var errRequestTimeout = errors.New("timed out making request")
+
+// withTimeout returns ctx limited to d if d is not 0
+func withTimeout(ctx context.Context, d time.Duration) (context.Context,
+	context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// isTimeout returns true if err is the result of a time limit expiring
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errBodyTimeout) || errors.Is(err, errRequestTimeout) ||
+		(errors.As(err, &ne) && ne.Timeout())
+}
+
+// failure returns the category of the error that made a phase of
+// testing a site fail: dns, connect, tls or response, or the phase
+// whose time limit expired: dns_timeout, connect_timeout, tls_timeout,
+// response_header_timeout, body_read_timeout or request_timeout.
+// Requests are given the phase request and the phase that failed is
+// worked out from err.
+func failure(phase string, err error) string {
+	if errors.Is(err, errBodyTimeout) {
+		return "body_read_timeout"
+	}
+	if errors.Is(err, errRequestTimeout) {
+		return "request_timeout"
+	}
+
+	if phase == "request" {
+		var op *net.OpError
+		var handshake handshakeError
+		switch {
+		case errors.As(err, &handshake):
+			phase = "tls"
+		case errors.As(err, &op) && op.Op == "dial":
+			phase = "connect"
+		default:
+			phase = "response"
+		}
+	}
+
+	switch {
+	case !isTimeout(err):
+		return phase
+	case phase == "response":
+		return "response_header_timeout"
+	}
+	return phase + "_timeout"
+}
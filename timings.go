@@ -0,0 +1,40 @@
+package viascan
+
+import (
+	"fmt"
+	"time"
+)
+
+// milestones are the times at which a request was sent, its response
+// headers arrived and its body had been read. They are taken from
+// time.Now so that they use the monotonic clock and are not upset by
+// changes to the wall clock during a scan.
+type milestones struct {
+	start   time.Time
+	headers time.Time
+	done    time.Time
+}
+
+// ms returns d in milliseconds to a tenth of a millisecond
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
+
+// since returns the milestones as milliseconds after t separated by /
+func (m milestones) since(t time.Time) string {
+	return ms(m.start.Sub(t)) + "/" + ms(m.headers.Sub(t)) + "/" +
+		ms(m.done.Sub(t))
+}
+
+// setTimings records the milestones of the requests with and without
+// Via relative to when testing the site started and the gap between
+// the end of the request with no Via and the start of the one with Via
+func (s *site) setTimings(noVia, via response) {
+	if !s.cfg.timings {
+		return
+	}
+
+	s.timings = "no-via=" + noVia.times.since(s.started) + ";via=" +
+		via.times.since(s.started)
+	s.probeGap = ms(via.times.start.Sub(noVia.times.done))
+}
@@ -0,0 +1,161 @@
+package viascan
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// sniKey is the context key under which fetch records the name to send
+// in SNI when connecting to the site's origin
+type sniKey struct{}
+
+// sniName says that connections to addr should send name in SNI
+type sniName struct {
+	addr string
+	name string
+}
+
+// pinKey is the context key under which fetch records the address
+// given on the input line for the site's origin
+type pinKey struct{}
+
+// pinnedIP says that connections to host should be made to ip
+type pinnedIP struct {
+	host string
+	ip   net.IP
+}
+
+// withSNI returns a context asking for the Host of s to be sent in SNI
+// when its origin is dialed over TLS, for its origin to be dialed at
+// the address given on the input line if there was one, and for its
+// client parameters to be used with -client-fingerprint=constant.
+// Connections to other addresses (after a redirect, say) send their
+// own name and are looked up.
+func (s *site) withSNI(ctx context.Context) context.Context {
+	if s.ip != nil {
+		ctx = context.WithValue(ctx, pinKey{}, pinnedIP{host: originHost(s.origin),
+			ip: s.ip})
+	}
+
+	addr := s.origin
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+	name := s.host
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	if s.cfg.clientFingerprint == "constant" {
+		ctx = context.WithValue(ctx, clientKey{}, s.client)
+	}
+	return context.WithValue(ctx, sniKey{}, sniName{addr: addr,
+		name: strings.Trim(name, "[]")})
+}
+
+// handshakeError is returned when the TLS handshake with an origin
+// fails
+type handshakeError struct {
+	err error
+}
+
+func (e handshakeError) Error() string {
+	return "TLS handshake: " + e.err.Error()
+}
+
+func (e handshakeError) Unwrap() error {
+	return e.err
+}
+
+// tlsDialer returns a function that connects to an origin over TLS
+// offering nextProtos in ALPN and sending the name asked for by
+// withSNI. Certificates are not checked here so that sites with bad
+// certificates can still be compared; fetch records any problem with
+// them instead (see verifyTLS).
+func (cfg *config) tlsDialer(nextProtos []string) func(ctx context.Context,
+	network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		name, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if sni, ok := ctx.Value(sniKey{}).(sniName); ok && sni.addr == addr {
+			name = sni.name
+		}
+
+		c, err := cfg.dialOrigin(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &tls.Config{ServerName: name, InsecureSkipVerify: true,
+			NextProtos: nextProtos}
+		if t := timed(c); t != nil {
+			t.client.configure(config)
+		}
+		t := tls.Client(c, config)
+		hctx, cancel := withTimeout(ctx, cfg.timeouts.tls)
+		defer cancel()
+		err = traceTLS(ctx, func() (tls.ConnectionState, error) {
+			err := t.HandshakeContext(hctx)
+			return t.ConnectionState(), err
+		})
+		if err != nil {
+			c.Close()
+			return nil, handshakeError{err}
+		}
+
+		return t, nil
+	}
+}
+
+// verifyTLS checks the certificates an origin sent for name and returns
+// the problem found, if any: unknown_authority, hostname_mismatch,
+// expired or invalid_certificate
+func verifyTLS(state tls.ConnectionState, name string) string {
+	if len(state.PeerCertificates) == 0 {
+		return "invalid_certificate"
+	}
+
+	opts := x509.VerifyOptions{DNSName: name, CurrentTime: time.Now(),
+		Intermediates: x509.NewCertPool()}
+	for _, c := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &unknown):
+		return "unknown_authority"
+	case errors.As(err, &hostname):
+		return "hostname_mismatch"
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "expired"
+	}
+	return "invalid_certificate"
+}
+
+// tlsFailure returns the TLS problem that made a request fail: not_tls
+// if the origin did not answer with TLS or handshake_failed, or empty
+// if the failure had nothing to do with TLS
+func tlsFailure(err error) string {
+	var handshake handshakeError
+	if !errors.As(err, &handshake) {
+		return ""
+	}
+
+	var record tls.RecordHeaderError
+	if errors.As(err, &record) {
+		return "not_tls"
+	}
+	return "handshake_failed"
+}
@@ -0,0 +1,134 @@
+package viascan
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// trailerPrefix starts every trailer line written by -trailer
+const trailerPrefix = "#trailer,"
+
+// trailer keeps a rolling checksum of output lines and produces a
+// trailer line after every chunk of lines. Each chunk's checksum
+// includes the previous chunk's so that missing, reordered or
+// truncated chunks are detected by verify.
+type trailer struct {
+	every int       // Number of results in a chunk
+	h     hash.Hash // Checksum of the current chunk
+	lines int       // Number of lines in the current chunk
+	total int       // Number of lines written in total
+	prev  string    // Checksum from the previous trailer
+}
+
+func newTrailer(every int) *trailer {
+	t := &trailer{every: every}
+	t.reset()
+	return t
+}
+
+func (t *trailer) reset() {
+	t.h = sha256.New()
+	t.h.Write([]byte(t.prev))
+	t.lines = 0
+}
+
+// add adds a line to the checksum and returns a trailer line if the
+// chunk is complete or "" if not
+func (t *trailer) add(line string) string {
+	t.h.Write([]byte(line + "\n"))
+	t.lines++
+	t.total++
+	if t.every == 0 || t.lines < t.every {
+		return ""
+	}
+	return t.end(false)
+}
+
+// end returns the trailer line for the current chunk and starts a new
+// one. The final trailer in the output is marked so that truncation at
+// a chunk boundary is detected.
+func (t *trailer) end(final bool) string {
+	t.prev = hex.EncodeToString(t.h.Sum(nil))
+	line := fmt.Sprintf("%s%d,%d,%s", trailerPrefix, t.lines, t.total, t.prev)
+	if final {
+		line += ",end"
+	}
+	t.reset()
+	return line
+}
+
+// verifyCommand implements the verify subcommand which checks the
+// trailers in a file written with -trailer
+func verifyCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: viascan verify results.csv\n")
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", args[0], err)
+		return 1
+	}
+	defer f.Close()
+
+	t := newTrailer(0)
+	chunks := 0
+	line := 0
+	final := false
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line++
+		text := scan.Text()
+		if final {
+			fmt.Fprintf(os.Stderr, "%s:%d: data after the final trailer\n", args[0], line)
+			return 1
+		}
+		if !strings.HasPrefix(text, trailerPrefix) {
+			t.add(text)
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(text, trailerPrefix), ",")
+		final = len(parts) == 4 && parts[3] == "end"
+		if len(parts) != 3 && !final {
+			fmt.Fprintf(os.Stderr, "%s:%d: bad trailer\n", args[0], line)
+			return 1
+		}
+		lines, _ := strconv.Atoi(parts[0])
+		total, _ := strconv.Atoi(parts[1])
+		if lines != t.lines || total != t.total {
+			fmt.Fprintf(os.Stderr,
+				"%s:%d: trailer expects %d lines (%d in total) but found %d (%d in total)\n",
+				args[0], line, lines, total, t.lines, t.total)
+			return 1
+		}
+		if sum := t.end(final); sum != text {
+			fmt.Fprintf(os.Stderr, "%s:%d: checksum mismatch\n", args[0], line)
+			return 1
+		}
+		chunks++
+	}
+	if scan.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", args[0], scan.Err())
+		return 1
+	}
+
+	if chunks == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no trailers found\n", args[0])
+		return 1
+	}
+	if !final {
+		fmt.Fprintf(os.Stderr, "%s: no final trailer, file is truncated\n", args[0])
+		return 1
+	}
+
+	fmt.Printf("%s: %d lines in %d chunks verified\n", args[0], t.total, chunks)
+	return 0
+}
@@ -0,0 +1,111 @@
+package viascan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// trailed returns n output lines with a trailer after every chunk
+// lines, as -trailer writes them
+func trailed(n, chunk int) []string {
+	t := newTrailer(chunk)
+	var lines []string
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf("example%d.com,t,t,1%d", i, i)
+		lines = append(lines, line)
+		if tr := t.add(line); tr != "" {
+			lines = append(lines, tr)
+		}
+	}
+	return append(lines, t.end(true))
+}
+
+func TestTrailer(t *testing.T) {
+	t.Run("lines", func(t *testing.T) {
+		tests := []struct {
+			n, chunk int
+			trailers []string // Lines and total of each trailer
+		}{
+			{0, 2, []string{"0,0"}},
+			{1, 2, []string{"1,1"}},
+			{2, 2, []string{"2,2", "0,2"}},
+			{5, 2, []string{"2,2", "2,4", "1,5"}},
+			{3, 1, []string{"1,1", "1,2", "1,3", "0,3"}},
+		}
+		for _, tt := range tests {
+			var got []string
+			for _, line := range trailed(tt.n, tt.chunk) {
+				if strings.HasPrefix(line, trailerPrefix) {
+					f := strings.Split(strings.TrimPrefix(line, trailerPrefix), ",")
+					got = append(got, f[0]+","+f[1])
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.trailers, " ") {
+				t.Errorf("%d lines in chunks of %d: got trailers %v, want %v",
+					tt.n, tt.chunk, got, tt.trailers)
+			}
+		}
+	})
+
+	// Each chunk's checksum covers the one before it so the same lines
+	// give a different checksum in a different place
+	t.Run("chained", func(t *testing.T) {
+		a, b := newTrailer(1), newTrailer(1)
+		b.add("first")
+		x, y := a.add("second"), b.add("second")
+		if x[len(x)-64:] == y[len(y)-64:] {
+			t.Errorf("checksum does not depend on the previous chunk: %s", x)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		edit  func(lines []string) []string
+		valid bool
+	}{
+		{"intact", func(l []string) []string { return l }, true},
+		{"line changed", func(l []string) []string {
+			l[1] = strings.Replace(l[1], ",t,", ",f,", 1)
+			return l
+		}, false},
+		{"line dropped", func(l []string) []string {
+			return append(l[:1:1], l[2:]...)
+		}, false},
+		{"chunks swapped", func(l []string) []string {
+			return append(append(append([]string{}, l[3:6]...), l[:3]...),
+				l[6:]...)
+		}, false},
+		{"chunk dropped", func(l []string) []string {
+			return append(l[:3:3], l[6:]...)
+		}, false},
+		{"cut at a chunk", func(l []string) []string { return l[:6] }, false},
+		{"cut in a chunk", func(l []string) []string { return l[:7] }, false},
+		{"data after the end", func(l []string) []string {
+			return append(l, "example.org,t,t,1")
+		}, false},
+		{"bad trailer", func(l []string) []string {
+			l[2] = trailerPrefix + "2"
+			return l
+		}, false},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.edit(trailed(7, 2))
+			file := filepath.Join(dir, fmt.Sprintf("results%d.csv", i))
+			err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"),
+				0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := verifyCommand([]string{file}) == 0; got != tt.valid {
+				t.Errorf("verifyCommand is %t, want %t for\n%s", got, tt.valid,
+					strings.Join(lines, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// browserUA is the User-Agent sent by the user-agent difference and
+// by -ua-compare
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// triggers are the differences that a proxy might make to a request,
+// in the order they are tried. Header order is not among them as Go
+// writes headers in its own order.
+var triggers = []struct {
+	name  string
+	apply func(s *site, req *http.Request)
+}{
+	{"via", func(s *site, req *http.Request) {
+		req.Header.Set("Via", s.viaToSend())
+	}},
+	{"user-agent", func(s *site, req *http.Request) {
+		req.Header.Set("User-Agent", browserUA)
+	}},
+	{"accept-encoding", func(s *site, req *http.Request) {
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	}},
+}
+
+// findTrigger repeats the request with no Via applying every set of
+// triggers, smallest first, until one makes the response differ in
+// the way the response with Via did (see compareTo). It returns the
+// names of that set joined by + (via if Via alone is enough) or none
+// if no set reproduces the difference, which suggests it came from
+// something other than the request such as a deploy or cache expiry.
+func (s *site) findTrigger(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request, noVia response) string {
+	want := s.verdict()
+	n := len(triggers)
+	for size := 1; size <= n; size++ {
+		for set := 1; set < 1<<uint(n); set++ {
+			if bits(set) != size {
+				continue
+			}
+
+			t := req.Clone(req.Context())
+			t.Header.Del("Via")
+			var names []string
+			for i, tr := range triggers {
+				if set&(1<<uint(i)) != 0 {
+					tr.apply(s, t)
+					names = append(names, tr.name)
+				}
+			}
+
+			r, err := s.fetch(l, client, transport, t)
+			if compareTo(noVia, r, err) == want {
+				return strings.Join(names, "+")
+			}
+		}
+	}
+
+	return "none"
+}
+
+// bits returns the number of bits set in n
+func bits(n int) int {
+	c := 0
+	for ; n != 0; n &= n - 1 {
+		c++
+	}
+	return c
+}
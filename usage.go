@@ -0,0 +1,58 @@
+package viascan
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// usageCounts is what a run used, reported at the end of it so that
+// the cost of scans can be planned. Bytes are counted on the TCP
+// connections to origins (see timedConn) so they include headers and
+// TLS but not HTTP/3.
+type usageCounts struct {
+	sent     int64 // Bytes sent to origins
+	received int64 // Bytes received from origins
+
+	sync.Mutex
+	requests map[string]int64 // Requests made by the probe they were for
+}
+
+// request counts a request made for probe
+func (u *usageCounts) request(probe string) {
+	if probe == "" {
+		probe = "other"
+	}
+	u.Lock()
+	u.requests[probe]++
+	u.Unlock()
+}
+
+// report writes the usage of a run that took wall time to w, with the
+// cost of the bytes sent and received at costPerGB if it is not 0
+func (u *usageCounts) report(w io.Writer, wall time.Duration,
+	costPerGB float64) {
+	sent, received := atomic.LoadInt64(&u.sent), atomic.LoadInt64(&u.received)
+	fmt.Fprintf(w, "Usage: %s wall time, %d bytes sent, %d bytes received\n",
+		wall.Round(time.Millisecond), sent, received)
+
+	u.Lock()
+	var probes []string
+	var total int64
+	for probe, n := range u.requests {
+		probes = append(probes, fmt.Sprintf("%s %d", probe, n))
+		total += n
+	}
+	u.Unlock()
+	sort.Strings(probes)
+	fmt.Fprintf(w, "Requests: %d (%s)\n", total, strings.Join(probes, ", "))
+
+	if costPerGB > 0 {
+		fmt.Fprintf(w, "Estimated cost: %.4g at %g per GB\n",
+			float64(sent+received)/1e9*costPerGB, costPerGB)
+	}
+}
@@ -0,0 +1,37 @@
+package viascan
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// botUA is the User-Agent of a crawler sent by -ua-compare
+const botUA = "Mozilla/5.0 (compatible; viascanbot/1.0; +https://github.com/jgrahamc/viascan)"
+
+// comparedUAs are the User-Agents sent by -ua-compare, in the order
+// their results are reported
+var comparedUAs = []string{browserUA, botUA}
+
+// testUserAgents repeats the requests with and without Via once with
+// each of comparedUAs and returns how each response differs from the
+// one with no Via and from the one with Via (see compareTo), separated
+// by / (e.g. identical/blocked)
+func (s *site) testUserAgents(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request,
+	noVia, via response) (string, string) {
+	var noViaResults, viaResults []string
+	for _, ua := range comparedUAs {
+		u := req.Clone(req.Context())
+		u.Header.Set("User-Agent", ua)
+		u.Header.Del("Via")
+		r, err := s.fetch(l, client, transport, u)
+		noViaResults = append(noViaResults, compareTo(noVia, r, err))
+
+		u.Header.Set("Via", s.viaToSend())
+		r, err = s.fetch(l, client, transport, u)
+		viaResults = append(viaResults, compareTo(via, r, err))
+	}
+
+	return strings.Join(noViaResults, "/"), strings.Join(viaResults, "/")
+}
@@ -17,7 +17,14 @@
 // For example, the above might output:
 //
 // cloudflare.com,www.cloudflare.com,t,t,t,2038,2038,gzip,gzip,
-// cloudflare-nginx,cloudflare-nginx
+// cloudflare-nginx,cloudflare-nginx,,-,-,,f,f,chunked,chunked,f,f,t,
+// gzip/gzip/gzip/gzip,gzip/deflate/gzip/deflate,t,identical,
+// via-only,-,-,-,-,,,HTTP/1.1,HTTP/1.1,200,200,,,f,,,-,,,,-,,
+// 9f86d0...,9f86d0...,t,1.2/10.4/21.7/48.3/52.9,-/-/-/45.1/49.0,,-,
+// -,,,full,12.3,48.0,+x-forwarded-for,-via/+x-forwarded-for,none,
+// 103,none,1448,1448,,dns,192.0.2.1,ipv4,0,none,192.0.2.1/192.0.2.2,
+// ,system,40211,40215,,gzip/deflate,none/none/gzip/br/br,
+// none/none/gzip/br/br,f,,,,,f,,
 //
 // Breaking that down:
 //
@@ -29,28 +36,226 @@
 // 2038,                     Size in bytes of the response to GET / with no Via
 // 2038,                     Size in bytes of the response to GET / with Via
 // gzip,                     Content-Encoding in response with no Via header
+//                           (codings joined by +, e.g. gzip+deflate)
 // gzip,                     Content-Encoding in response with a Via header
 // cloudflare-nginx,         Server in response with no Via header
-// cloudflare-nginx          Server in response with a Via header
+// cloudflare-nginx,         Server in response with a Via header
+//                           Expansion values used (see -expand),
+// -,                        t if no-cache changed the response with no Via
+// -,                        t if no-cache changed the response with Via
+//                           Host that redirected here (see -follow-scan-depth),
+// f,                        t if the Via and no-Via responses used different
+//                           HTTP versions (e.g. HTTP/2.0 and HTTP/1.1)
+// f,                        t if the visible text of the HTML or text bodies
+//                           differs (- if either body is not text)
+// chunked,                  Body framing with no Via (length, chunked, eof,
+//                           h2 or h3)
+// chunked,                  Body framing with Via
+// f,                        t if the framing differs with and without Via
+// f,                        t if the request with no Via reused a pooled
+//                           connection (see -pool)
+// t,                        t if the request with Via reused a pooled connection
+// gzip/gzip/gzip/gzip,      Encodings chosen with no Via for each Accept-Encoding
+//                           order (see -ae-order)
+// gzip/deflate/gzip/deflate,
+//                           Encodings chosen with Via for each Accept-Encoding
+//                           order
+// t,                        t if the encoding chosen depends on the order or
+//                           spacing of Accept-Encoding only when Via is present
+// identical,                Summary: unresolved, allowlisted (every request was
+//                           reset or got a 403), failed, blocked (only the Via
+//                           request failed or got a 403),
+//                           decompression_bomb_suspected, encoding, size or
+//                           identical
+// via-only,                 Whether requests with CDN-Loop were rejected: none,
+//                           via-only, no-via-only or always (see -cdn-loop)
+// -,                        Whether the body with no Via was resumed with a
+//                           Range request (see -resume)
+// -,                        Whether the body with Via was resumed
+// -,                        Whether the size with no Via is estimated from a
+//                           sample (see -sample-bytes)
+// -,                        Whether the size with Via is estimated
+//                           TLS problem with https: not_tls, handshake_failed,
+//                           unknown_authority, hostname_mismatch, expired or
+//                           invalid_certificate (empty if none),
+//                           Why the site failed: dns, connect, tls, response
+//                           or the phase that timed out (e.g. connect_timeout),
+// HTTP/1.1,                 HTTP version of the response with no Via
+// HTTP/1.1,                 HTTP version of the response with Via
+// 200,                      HTTP status of the response with no Via
+// 200,                      HTTP status of the response with Via
+//                           Whether stacked codings (e.g. gzip+deflate)
+//                           decode with no Via: ok, misordered, undecodable,
+//                           unknown (empty if not stacked),
+//                           Whether stacked codings decode with Via,
+// f,                        t if codings are misordered or undecodable
+//                           only with Via
+//                           Verdict in the previous run (see -history-db),
+//                           Time of that run,
+// -,                        t if the verdict, encodings or Server headers
+//                           changed since that run
+//                           Milliseconds after testing started at which each
+//                           request was sent, got headers and was read
+//                           (e.g. no-via=0.1/9.8/12.0;via=12.1/20.3/22.5)
+//                           with -timings,
+//                           Milliseconds between the end of the request with
+//                           no Via and the start of the one with Via,
+//                           How the responses with X-Forwarded-For,
+//                           X-Forwarded-Proto and X-Real-IP (and no Via)
+//                           differ, separated by / (e.g.
+//                           identical/encoding/identical) with -forwarded,
+// -,                        t if any of them changes the response the way Via
+//                           does
+//                           Smallest set of changes that reproduces the Via
+//                           difference (e.g. via or via+user-agent, none if
+//                           nothing does) with -trigger-search,
+// 9f86d0...,                SHA-256 of the body with no Via as received
+//                           (shortened here)
+// 9f86d0...,                SHA-256 of the body with Via
+// t,                        t if the bodies are byte-for-byte the same
+// 1.2/10.4/21.7/48.3/52.9,  DNS/connect/TLS/TTFB/total in ms with
+//                           no Via
+// -/-/-/45.1/49.0,          DNS/connect/TLS/TTFB/total in ms with Via
+//                           Origins that did not respond before this
+//                           one,
+// -,                        t if a WebSocket upgrade with no Via
+//                           succeeded (-websocket)
+// -,                        t if a WebSocket upgrade with Via succeeded
+//                           Encoding with the body with no Via
+//                           offered as a dictionary (-dictionary),
+//                           Encoding with the body with Via offered
+//                           as a dictionary,
+// full,                     Request headers accepted: full or minimal
+// 12.3,                     TCP handshake ms with no Via or -
+// 48.0,                     TCP handshake ms with Via or -
+// +x-forwarded-for,         Headers changed on the way to -echo-path with no Via
+// -via/+x-forwarded-for,    Headers changed on the way to -echo-path with Via
+// none,                     Repeated headers that differ with Via
+// 103,                      1xx responses with no Via or none
+// none,                     1xx responses with Via or none
+// 1448,                     TCP MSS with no Via or -
+// 1448,                     TCP MSS with Via or -
+//                           Whether a stalled request worked
+//                           with a small MSS,
+// dns,                      How the origin's address was found:
+//                           dns, ip or input
+// 192.0.2.1,                Address the request with no Via
+//                           was sent to
+// ipv4,                     Address family used: ipv4 or ipv6
+// 0,                        Cache poisoning risk from 0 to 100
+// none,                     What contributed to risk separated by /
+// 192.0.2.1/192.0.2.2,      Addresses the origin resolved to separated by /
+//                           CNAMEs followed from the origin separated by /,
+// system,                   -client-fingerprint policy: system, constant or vary
+// 40211,                    Local port (and TLS parameters chosen by
+//                           -client-fingerprint) with no Via
+// 40215,                    Local port (and TLS parameters) with Via
+//                           -proxy the requests went through,
+// gzip/deflate,             Accept-Encoding sent, joined by /
+// none/none/gzip/br/br,     Encodings chosen with no Via for
+//                           each of the -ae-matrix values
+// none/none/gzip/br/br,     Encodings chosen with Via for each
+// f,                        t if any of them differ with Via
+//                           Redirects followed with no Via,
+//                           Redirects followed with Via,
+//                           URL of the response with no Via,
+//                           URL of the response with Via,
+// f,                        t if Via changed the redirects
+//                           How a browser's and a crawler's
+//                           User-Agent change the response with
+//                           no Via (see -ua-compare),
+//                           The same with Via
+//
+// The input can be built from a list of hosts and a list of origins
+// with
+//
+//      ./viascan pair hosts.txt origins.txt | ./viascan
+//
+// which pairs every host with every origin (or, with -zip, the first
+// host with the first origin and so on), removing duplicates.
+//
+// Origins starting https:// (or every origin, with -https) are
+// contacted over TLS with the Host sent in SNI. Certificates that do
+// not verify for the Host are recorded in the tlsError field rather than
+// stopping the test.
+//
+// An origin can end with a path to request instead of / (e.g.
+// www.example.com,192.0.2.1/app.js) and a line can be a full URL on its
+// own (e.g. https://www.example.com/app.js). Paths other than / are
+// recorded in the probe field (e.g. path=/app.js).
+//
+// The -expand option turns each input line into a matrix of probes.
+// For example, -expand="schemes=http,https;encodings=gzip,br" tests
+// each line four times and the probe field of each output line
+// records which combination was used (e.g. schemes=https;encodings=br).
+// With -expand=protocols=h1,h2,h3 each line is tested over HTTP/1.1,
+// HTTP/2 and HTTP/3 and the HTTP version of each response is recorded.
+//
+// The -expand-hosts option adds Host header variants for each input
+// line. For example, with -expand-hosts=www,apex the input line
+// example.com,192.0.2.1 is also tested with the Host www.example.com.
+// Variants are recorded in the probe field (e.g. host=www).
+//
+// The -no-cache=compare option repeats each request with Cache-Control:
+// no-cache and Pragma: no-cache added and records whether the response
+// changed, which separates caching-layer behavior from the origin's.
+//
+// With -follow-scan-depth=N a site that redirects to a different host
+// causes that host to be scanned as well (up to N redirects away from
+// an input line). Each site is scanned at most once.
+//
+// With -trailer=N a line starting #trailer, is written after every N
+// output lines (and at the end) containing a count of lines and a
+// rolling checksum. A results file can then be checked with
+//
+//      ./viascan verify results.csv
+//
+// which fails if the file was corrupted or truncated.
+//
+// With -scope=scope.signed -scope-key=name.pub only domains and
+// networks listed in the scope file are contacted. The file must be
+// signed (see viascan scope) and is rejected if the signature does not
+// match. Sites out of scope are reported with a - for both requests.
+//
+// Scanning is the scan subcommand, which is the default when viascan is
+// run with only flags. Other subcommands work on its output:
+//
+//      ./viascan diff yesterday.csv today.csv
+//      ./viascan report today.csv
+//
+// list the sites whose verdict changed and count the sites with each
+// verdict. ./viascan selftest checks viascan against local servers
+// with known behavior and ./viascan help lists every subcommand.
 
-package main
+package viascan
 
 import (
-	"bufio"
-	"flag"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/bogdanovich/dns_resolver"
+	"github.com/miekg/dns"
 )
 
-var resolverName string
-var dump *bool
+// viaToSend returns the value of the Via header to send to s
+func (s *site) viaToSend() string {
+	if s.viaValue != "" {
+		return s.viaValue
+	}
+	return s.cfg.viaHeader
+}
 
 // tri captures a tri-state. The value of yesno is true only is ran is
 // true
@@ -77,43 +282,467 @@ func (t tri) String() string {
 // site is a web site identified by its DNS name along with the state
 // of various tests performed on the site.
 type site struct {
+	cfg *config // Settings of the scan the site is part of
+
 	host   string // Host header that needs to be set
 	origin string // DNS name of the web site
+	ip     net.IP // Address of the origin given on the input line (see parseLine)
+	split  bool   // Whether ip is one of the addresses found with -all-ips
+
+	otherIPs []net.IP // Other addresses of the origin to test (see -all-ips)
+
+	fallbacks    []string // Origins to try if origin does not respond (see testOrigins)
+	triedOrigins []string // Origins tried before origin that did not respond
+
+	scheme   string // URL scheme to use (http or https)
+	encoding string // Accept-Encoding header to send
+	path     string // Path to request if not / (see parseLine)
+	viaValue string // Via header to send instead of -via (see -via-file)
+	probe    string // Expansion values applied to this site (see -expand)
+	proto    string // HTTP version to use (see newTransport)
+
+	state   *workerState // State of the worker testing this site
+	dump    *siteDump    // Requests and responses when -dump-dir is set
+	running string       // Probe being run, to count its requests under
+
+	line *inputLine // Input line the site came from (see -checkpoint)
+
+	extra []extraField // Fields added by -post-process
+
+	depth        int        // Number of redirects followed to reach this site
+	followedFrom string     // Host that redirected to this site
+	redirects    []*url.URL // Other hosts this site redirected to
 
 	resolves tri // Whether the name resolves
 	noVia    tri // Whether request without Via header works
 	via      tri // Whether request with Via header works
 
+	asn        string // AS announcing the origin's address (see -output-split-by)
+	resolution string // How the origin's address was found: dns, ip, input or proxy
+	addr       string // Address the request with no Via was sent to
+
+	resolvedIPs []net.IP // Addresses the origin's name resolved to
+	cnames      []string // Names the origin's name is an alias of in order (see cnameChain)
+
 	noViaSize int // Size of the body returned with no Via header
 	viaSize   int // Size of the body returned with a Via header
 
 	noViaEncoding string // Content-Encoding header with no Via header
 	viaEncoding   string // Content-Encoding header with Via header
 
+	started  time.Time // When testing the site started
+	timings  string    // Milestones of each request (see setTimings)
+	probeGap string    // Milliseconds between the no Via and Via requests
+
+	lastVerdict string // Verdict in the previous run (see -history-db)
+	lastSeen    string // Time of the previous run the site was in
+	changed     tri    // Whether the site changed since the previous run
+
+	noViaLayers   string // Whether stacked codings decode with no Via (see checkLayers)
+	viaLayers     string // Whether stacked codings decode with Via
+	layersViaOnly tri    // Whether codings are stacked wrongly only with Via
+
 	noViaServer string // Server header with no Via header
 	viaServer   string // Server header with Via header
+
+	noViaStatus int // HTTP status code with no Via header
+	viaStatus   int // HTTP status code with Via header
+
+	noViaNoCacheChanged tri // Whether no-cache changed the response with no Via header
+	viaNoCacheChanged   tri // Whether no-cache changed the response with Via header
+
+	protoDivergence tri // Whether the Via and no-Via responses used different HTTP versions
+
+	textDiffers tri // Whether the visible text of the Via and no-Via bodies differs
+
+	noViaFraming   string // Body framing with no Via header (see framing())
+	viaFraming     string // Body framing with Via header
+	framingDiffers tri    // Whether the Via and no-Via responses were framed differently
+
+	noViaReused tri // Whether the no Via request reused a pooled connection
+	viaReused   tri // Whether the Via request reused a pooled connection
+
+	noViaAEOrder   string // Encodings chosen for each of aeOrders with no Via header
+	viaAEOrder     string // Encodings chosen for each of aeOrders with Via header
+	aeOrderViaOnly tri    // Whether the choice depends on Accept-Encoding order only with Via
+
+	noViaAEMatrix   string // Encodings chosen for each of aeMatrixValues with no Via header
+	viaAEMatrix     string // Encodings chosen for each of aeMatrixValues with Via header
+	aeMatrixDiffers tri    // Whether any of them differ with Via
+
+	bombSuspected bool // Whether either body decompressed to more than the limits
+	allowlisted   bool // Whether the origin refuses every request (see test)
+
+	cdnLoop string // How CDN-Loop is handled (see testCDNLoop)
+
+	forwarded        string // How X-Forwarded-For etc. change the response (see testForwarded)
+	forwardedLikeVia tri    // Whether any of them changes it the way Via does
+
+	noViaUA string // How other User-Agents change the response with no Via (see testUserAgents)
+	viaUA   string // How other User-Agents change the response with Via
+
+	noViaHash       string // SHA-256 of the body with no Via header
+	viaHash         string // SHA-256 of the body with Via header
+	bodiesIdentical tri    // Whether the bodies are byte-for-byte the same
+	noViaPhases     string // How long each part of the request with no Via took
+	viaPhases       string // How long each part of the request with Via took
+
+	noViaRTT string // TCP handshake time of the connection with no Via (see handshakeRTT)
+	viaRTT   string // TCP handshake time of the connection with Via
+
+	noViaEcho string // How the headers that reached -echo-path differ with no Via (see echoDiff)
+	viaEcho   string // How the headers that reached -echo-path differ with Via
+
+	headerDiffs string // Headers often repeated whose values differ with Via (see listHeaders)
+
+	noViaInterim string // 1xx responses received with no Via (see interim)
+	viaInterim   string // 1xx responses received with Via
+
+	noViaMSS    string // MSS of the connection with no Via (see connMSS)
+	viaMSS      string // MSS of the connection with Via
+	pmtuFailure tri    // Whether a stalled request worked with a small MSS (see checkPMTU)
+
+	client      clientParams // Client parameters with -client-fingerprint=constant
+	noViaClient string       // Local port and TLS parameters with no Via (see clientSide)
+	viaClient   string       // Local port and TLS parameters with Via
+
+	risk        string // Cache poisoning risk from 0 to 100 (see assessRisk)
+	riskFactors string // What contributed to risk
+
+	noViaRedirects  string // Redirects followed with no Via (see redirectChain)
+	viaRedirects    string // Redirects followed with Via
+	noViaFinalURL   string // URL of the response with no Via if it was redirected
+	viaFinalURL     string // URL of the response with Via if it was redirected
+	redirectDiffers tri    // Whether the requests were redirected differently
+
+	noViaDictionary string // Content-Encoding when the body with no Via was offered as a dictionary (see testDictionary)
+	viaDictionary   string // Content-Encoding when the body with Via was offered as a dictionary
+
+	profile string // Request headers the origin accepted: full or minimal (see retryMinimal)
+
+	noViaWebSocket tri // Whether a WebSocket upgrade with no Via succeeded (see testWebSocket)
+	viaWebSocket   tri // Whether a WebSocket upgrade with Via succeeded
+
+	trigger string // Smallest set of differences that reproduces the Via difference (see findTrigger)
+
+	noViaResumed tri // Whether the body with no Via was resumed with a Range request
+	viaResumed   tri // Whether the body with Via was resumed with a Range request
+
+	noViaSampled tri // Whether the size with no Via is estimated from a sample
+	viaSampled   tri // Whether the size with Via is estimated from a sample
+
+	tlsError string // TLS problem with the origin (see verifyTLS and tlsFailure)
+
+	failure string // Why the test failed (see failure())
+
+	noViaProto string // HTTP version of the response with no Via header
+	viaProto   string // HTTP version of the response with Via header
 }
 
 // test tests a site and looks at Via support
 func (s *site) test(l *os.File) {
-	resolver := dns_resolver.New([]string{resolverName})
+	s.started = time.Now()
+	if s.cfg.clientFingerprint == "constant" {
+		s.client = randomClientParams()
+	}
+	if s.cfg.dead.known(s.origin) {
+		s.logf(l, "Skipped as known to be dead")
+		return
+	}
 
 	// Check that the origin server resolves
 
+	s.setPhase("resolving")
 	s.resolves.ran = true
 	name := s.origin
-	if net.ParseIP(name) == nil {
-		_, err := resolver.LookupHost(name)
+	hostname := originHost(name)
+	ips := []net.IP{net.ParseIP(hostname)}
+	s.resolution = "ip"
+	if s.ip != nil {
+		ips = []net.IP{s.ip}
+		s.resolution = "input"
+		if s.split {
+			s.resolution = "dns"
+		}
+	}
+	if ips[0] == nil && s.cfg.proxyDNS() {
+		// With socks5h the name is looked up by the -proxy
+
+		s.resolution = "proxy"
+		ips = nil
+		s.resolved(nil, nil)
+	} else if ips[0] == nil {
+		s.resolution = "dns"
+		var err error
+		var msg *dns.Msg
+		ips, msg, err = s.cfg.resolver.Lookup(hostname)
+		s.dumpDNS(hostname, msg)
+		s.resolved(ips, err)
+		s.cnames = cnameChain(msg)
 		if err != nil {
 			s.logf(l, "Error resolving name: %s", err)
+			s.failure = failure("dns", err)
 			s.resolves.yesno = false
 			return
 		}
+		if s.cfg.allIPs {
+			if s.cfg.ipFamily == 0 && ips[0].To4() != nil {
+				ips = append(ips, s.cfg.originIPv6(hostname)...)
+			}
+			s.ip, s.split, s.otherIPs = ips[0], true, ips[1:]
+		}
+		s.resolvedIPs = ips
+	} else {
+		s.resolved(ips, nil)
 	}
 	s.resolves.yesno = true
+	if len(ips) > 0 {
+		s.addr = ips[0].String()
+		if s.cfg.splitBy == "asn" {
+			s.asn = s.cfg.originASN(ips[0])
+		}
+	}
 
-	protocol := "http://"
+	// Nothing is sent to a site that is not in the -scope
+
+	if !s.cfg.scope.allowed(hostname, ips) {
+		s.logf(l, "Not in scope")
+		return
+	}
 
+	protocol := s.scheme + "://"
+
+	transport := s.cfg.pools[s.proto]
+	if transport == nil {
+		transport = s.cfg.roundTripper(s.cfg.newTransport(s.proto), s.proto)
+	}
+
+	client := &http.Client{Transport: transport,
+		CheckRedirect: s.checkRedirect}
+	req, err := http.NewRequest(s.cfg.method, protocol+name+s.path, nil)
+	if err != nil {
+		s.logf(l, "Cannot make request: %s", err)
+		s.failure = "request"
+		return
+	}
+
+	req.Header.Set("Accept-Encoding", s.encoding)
+	if s.cfg.userAgent != "" {
+		req.Header.Set("User-Agent", s.cfg.userAgent)
+	}
+	req.Host = s.host
+	if s.cfg.noCache == "send" {
+		setNoCache(req)
+	}
+
+	if s.cfg.robots != nil {
+		s.setPhase("fetching robots.txt")
+		s.cfg.robots.honor(l, s, transport, protocol+name)
+	}
+
+	if !s.probeStart("no-via") {
+		return
+	}
+	s.setPhase("requesting without Via")
+	s.noVia.ran = true
+	noVia, err := s.fetch(l, client, transport, req)
+	if s.cfg.minimalRetry && rejectsHeaders(noVia, err) {
+		if m, r, ok := s.retryMinimal(l, client, transport, req); ok {
+			req, noVia, err = m, r, nil
+			s.profile = "minimal"
+		}
+	}
+	if err != nil && s.cfg.pmtuCheck && stalled(err) {
+		s.pmtuFailure = s.checkPMTU(l, req)
+	}
+	s.probeComplete("no-via", noVia.status, err)
+	s.tlsError = noVia.tls
+	if err != nil {
+		s.failure = failure("request", err)
+
+		// An origin that resets the connection whether or not Via is
+		// sent is most likely only accepting connections from an
+		// allowlist of addresses rather than reacting to Via
+
+		if isReset(err) && s.probeStart("via") {
+			req.Header.Set("Via", s.viaToSend())
+			s.setPhase("requesting with Via")
+			s.via.ran = true
+			via, err := s.fetch(l, client, transport, req)
+			s.probeComplete("via", via.status, err)
+			s.via.yesno = err == nil
+			s.allowlisted = isReset(err)
+		}
+		return
+	}
+	s.noVia.yesno = true
+	if s.profile == "" {
+		s.profile = "full"
+	}
+	s.noViaSize = noVia.size
+	s.noViaEncoding = noVia.encoding
+	s.noViaServer = noVia.server
+	s.noViaStatus = noVia.status
+	s.noViaFraming = noVia.framing
+	s.noViaReused = tri{ran: true, yesno: noVia.reused}
+	s.noViaResumed = tri{ran: s.cfg.resumes > 0, yesno: noVia.resumed}
+	s.noViaSampled = tri{ran: s.cfg.sampleBytes > 0, yesno: noVia.sampled}
+	s.noViaLayers = noVia.layers
+	s.noViaInterim = interim(noVia.interim)
+	s.noViaMSS = mss(noVia.mss)
+	s.noViaClient = noVia.client
+	s.noViaRedirects, s.noViaFinalURL = noVia.redirects, noVia.final
+	if noVia.addr != "" {
+		s.addr = noVia.addr
+	}
+
+	// Now add the Via header to the same request and repeate
+
+	req.Header.Set("Via", s.viaToSend())
+
+	if !s.probeStart("via") {
+		return
+	}
+	s.setPhase("requesting with Via")
+	s.via.ran = true
+	via, err := s.fetch(l, client, transport, req)
+	if err != nil && s.cfg.pmtuCheck && stalled(err) {
+		s.pmtuFailure = s.checkPMTU(l, req)
+	}
+	s.probeComplete("via", via.status, err)
+	if s.tlsError == "" {
+		s.tlsError = via.tls
+	}
+	if err != nil {
+		s.failure = failure("request", err)
+		s.noCacheProbe(l, client, transport, req, noVia, nil)
+		return
+	}
+	s.via.yesno = true
+	s.viaSize = via.size
+	s.viaEncoding = via.encoding
+	s.viaServer = via.server
+	s.viaStatus = via.status
+	s.viaFraming = via.framing
+	s.viaReused = tri{ran: true, yesno: via.reused}
+	s.viaResumed = tri{ran: s.cfg.resumes > 0, yesno: via.resumed}
+	s.viaSampled = tri{ran: s.cfg.sampleBytes > 0, yesno: via.sampled}
+	s.viaLayers = via.layers
+	s.viaInterim = interim(via.interim)
+	s.viaMSS = mss(via.mss)
+	s.viaClient = via.client
+	s.viaRedirects, s.viaFinalURL = via.redirects, via.final
+	s.redirectDiffers = tri{ran: true, yesno: noVia.redirects != via.redirects}
+	s.layersViaOnly = tri{ran: true, yesno: badLayers(via.layers) &&
+		!badLayers(noVia.layers)}
+	s.framingDiffers = tri{ran: true, yesno: noVia.framing != via.framing}
+	s.noViaHash = noVia.hash
+	s.viaHash = via.hash
+	s.bodiesIdentical = tri{ran: noVia.hash != "" && via.hash != "",
+		yesno: noVia.hash == via.hash}
+	s.noViaPhases = noVia.phases.String()
+	s.viaPhases = via.phases.String()
+	s.noViaRTT, s.viaRTT = rtt(noVia.rtt), rtt(via.rtt)
+	s.headerDiffs = noVia.lists.diff(via.lists)
+	s.setTimings(noVia, via)
+
+	s.bombSuspected = noVia.bomb || via.bomb
+	s.allowlisted = noVia.status == http.StatusForbidden &&
+		via.status == http.StatusForbidden
+	if noVia.hasText && via.hasText {
+		s.textDiffers = tri{ran: true, yesno: noVia.text != via.text}
+	}
+
+	s.noViaProto = noVia.proto
+	s.viaProto = via.proto
+	s.protoDivergence = tri{ran: true, yesno: noVia.proto != via.proto}
+	if s.protoDivergence.yesno {
+		s.logf(l, "Protocol %s with no Via, %s with Via", noVia.proto, via.proto)
+	}
+
+	// The optional probes run once the requests with and without Via
+	// are done so that -probe-rules can choose between them
+
+	s.noCacheProbe(l, client, transport, req, noVia, &via)
+	s.assessRisk(noVia, via)
+
+	if s.cfg.aeOrder && s.cfg.probeRules.allows("ae-order", s) && s.probeStart("ae-order") {
+		s.setPhase("testing Accept-Encoding order")
+		s.testAEOrder(l, client, transport, req)
+		s.probeComplete("ae-order", 0, nil)
+	}
+
+	if s.cfg.aeMatrix && s.cfg.probeRules.allows("ae-matrix", s) &&
+		s.probeStart("ae-matrix") {
+		s.setPhase("testing the Accept-Encoding matrix")
+		s.testAEMatrix(l, client, transport, req)
+		s.probeComplete("ae-matrix", 0, nil)
+	}
+
+	if s.cfg.cdnLoop != "" && s.cfg.probeRules.allows("cdn-loop", s) &&
+		s.probeStart("cdn-loop") {
+		s.setPhase("testing CDN-Loop")
+		s.cdnLoop = s.testCDNLoop(l, client, transport, req, noVia, via)
+		s.probeComplete("cdn-loop", 0, nil)
+	}
+
+	if s.cfg.forwarded && s.cfg.probeRules.allows("forwarded", s) &&
+		s.probeStart("forwarded") {
+		s.setPhase("testing forwarding headers")
+		var likeVia bool
+		s.forwarded, likeVia = s.testForwarded(l, client, transport, req,
+			noVia)
+		s.forwardedLikeVia = tri{ran: true, yesno: likeVia}
+		s.probeComplete("forwarded", 0, nil)
+	}
+
+	if s.cfg.uaCompare && s.cfg.probeRules.allows("ua-compare", s) &&
+		s.probeStart("ua-compare") {
+		s.setPhase("testing User-Agents")
+		s.noViaUA, s.viaUA = s.testUserAgents(l, client, transport, req,
+			noVia, via)
+		s.probeComplete("ua-compare", 0, nil)
+	}
+
+	if s.cfg.dictionary && s.cfg.probeRules.allows("dictionary", s) &&
+		s.probeStart("dictionary") {
+		s.setPhase("testing dictionary compression")
+		s.noViaDictionary, s.viaDictionary = s.testDictionary(l, client,
+			transport, req, noVia, via)
+		s.probeComplete("dictionary", 0, nil)
+	}
+
+	if s.cfg.echoPath != "" && s.cfg.probeRules.allows("echo", s) &&
+		s.probeStart("echo") {
+		s.setPhase("testing header echo")
+		s.noViaEcho, s.viaEcho = s.testEcho(l, transport, req)
+		s.probeComplete("echo", 0, nil)
+	}
+
+	if s.cfg.websocket && s.cfg.probeRules.allows("websocket", s) &&
+		s.probeStart("websocket") {
+		s.setPhase("testing WebSocket upgrade")
+		s.noViaWebSocket, s.viaWebSocket = s.testWebSocket(l, req)
+		s.probeComplete("websocket", 0, nil)
+	}
+
+	// The search only makes sense where Via made a difference
+
+	switch s.verdict() {
+	case "blocked", "encoding", "size":
+		if s.cfg.triggerSearch && s.cfg.probeRules.allows("trigger", s) &&
+			s.probeStart("trigger") {
+			s.setPhase("searching for the trigger")
+			s.trigger = s.findTrigger(l, client, transport, req, noVia)
+			s.probeComplete("trigger", 0, nil)
+		}
+	}
+}
+
+// newTransport creates the http.Transport used to contact origins
+// using proto: h1 for HTTP/1.1 only, h2 for HTTP/2 only, h3 for
+// HTTP/3 (see roundTripper) or empty to let HTTP/2 be negotiated over TLS
+func (cfg *config) newTransport(proto string) *http.Transport {
 	// Note: we disable compression in the http.Transport so that the
 	// Go library does not add the Accept-Encoding and does not do
 	// transparent decompression.
@@ -124,186 +753,413 @@ func (s *site) test(l *os.File) {
 	transport := &http.Transport{}
 	transport.DisableCompression = true
 
+	// HTTP/2 is negotiated over TLS just as a browser would so that
+	// origins that serve Via requests over a different protocol are
+	// spotted (the custom Dial below otherwise turns it off).
+
+	transport.ForceAttemptHTTP2 = true
+	nextProtos := []string{"h2", "http/1.1"}
+
+	switch proto {
+	case "h1":
+		transport.ForceAttemptHTTP2 = false
+		nextProtos = []string{"http/1.1"}
+	case "h2":
+		nextProtos = []string{"h2"}
+	}
+
 	// Custom dialer is needed to use special DNS resolver so that the
-	// default resolver can be overriden
+	// default resolver can be overriden, and TLS connections send the
+	// Host in SNI rather than the origin's name
 
-	transport.Dial = func(network, address string) (net.Conn, error) {
-		host, port, err := net.SplitHostPort(address)
-		if err != nil {
-			return nil, err
-		}
+	transport.DialContext = cfg.dialOrigin
+	transport.DialTLSContext = cfg.tlsDialer(nextProtos)
+	transport.ResponseHeaderTimeout = cfg.timeouts.responseHeader
+
+	return transport
+}
+
+// dialOrigin connects to an origin server using the -resolver and
+// refuses to connect to anything outside the -scope
+func (cfg *config) dialOrigin(ctx context.Context, network,
+	address string) (net.Conn, error) {
+	addr, err := cfg.resolveOrigin(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.dial(ctx, network, addr)
+}
 
-		if net.ParseIP(host) != nil {
-			return net.Dial(network, address)
+// resolveOrigin turns the host:port of an origin server into an
+// ip:port using the address given on the input line or the -resolver
+// and fails if it is outside the -scope
+func (cfg *config) resolveOrigin(ctx context.Context,
+	address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	if pin, ok := ctx.Value(pinKey{}).(pinnedIP); ok && pin.host == host {
+		if !cfg.scope.allowed(host, []net.IP{pin.ip}) {
+			return "", fmt.Errorf("%s is not in scope", address)
 		}
+		return net.JoinHostPort(pin.ip.String(), port), nil
+	}
 
-		ips, err := resolver.LookupHost(host)
-		if err != nil {
-			return nil, err
+	if ip := net.ParseIP(host); ip != nil {
+		if !cfg.scope.allowed("", []net.IP{ip}) {
+			return "", fmt.Errorf("%s is not in scope", address)
 		}
+		return address, nil
+	}
 
-		if len(ips) == 0 {
-			return nil, fmt.Errorf("Failed to get any IPs for %s", address)
+	if cfg.proxyDNS() {
+		if !cfg.scope.allowed(host, nil) {
+			return "", fmt.Errorf("%s is not in scope", address)
 		}
+		return address, nil
+	}
 
-		return net.Dial(network, net.JoinHostPort(ips[0].String(), port))
+	ips, err := traceDNS(ctx, host, func() ([]net.IP, error) {
+		return cfg.resolver.LookupHost(host)
+	})
+	if err != nil {
+		return "", err
 	}
 
-	client := &http.Client{Transport: transport}
-	req, err := http.NewRequest("GET", protocol+name, nil)
+	if len(ips) == 0 {
+		return "", fmt.Errorf("Failed to get any IPs for %s", address)
+	}
 
-	req.Header.Set("Accept-Encoding", "gzip,deflate")
-	req.Header.Set("Host", s.host)
+	if !cfg.scope.allowed(host, ips[:1]) {
+		return "", fmt.Errorf("%s is not in scope", address)
+	}
 
-	s.noVia.ran = true
-	if *dump {
-		fmt.Printf("%#v\n", req)
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// response is the part of an HTTP response that is compared between
+// requests
+type response struct {
+	size     int    // Size of the body
+	encoding string // Content-Encoding header (see normalEncoding)
+	layers   string // Whether stacked codings decode (see checkLayers)
+	server   string // Server header
+	proto    string // Protocol the response was received over (e.g. HTTP/2.0)
+	framing  string // How the end of the body was marked (see framing())
+	status   int    // HTTP status code
+	reused   bool   // Whether the request was sent on a pooled connection
+
+	hash    string   // SHA-256 of the body as received (empty if sampled)
+	hasText bool     // Whether the body is HTML or text
+	text    [32]byte // Checksum of the visible text in the body
+	bomb    bool     // Whether the body decompressed to more than the limits
+	resumed bool     // Whether the body was resumed with a Range request
+	sampled bool     // Whether only a sample of the body was read
+
+	tls string // TLS problem with the connection (see verifyTLS and tlsFailure)
+
+	times  milestones    // When the request was made (see -timings)
+	phases phases        // How long each part of the request took
+	rtt    time.Duration // TCP handshake time of the connection used (see handshakeRTT)
+	mss    int           // MSS of the connection used (see connMSS)
+	addr   string        // IP address the request was sent to
+
+	lists headerLists // Values of the headers that are often repeated (see listHeaders)
+
+	interim string // Status codes of any 1xx responses before the response (e.g. 103)
+
+	cache string // Header showing that a cache answered (see cacheHeader)
+
+	client string // Local port and TLS parameters of the connection (see clientSide)
+
+	redirects string // Redirects followed to reach the response (see redirectChain)
+	final     string // URL of the response if there were any redirects
+
+	dictionary offeredDictionary // Offer to be used as a dictionary (see -dictionary)
+}
+
+// fetchOnce performs a single HTTP request and summarizes the response
+func (s *site) fetchOnce(l *os.File, client *http.Client,
+	transport http.RoundTripper, req *http.Request) (response, error) {
+	var r response
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.reused = info.Reused
+			r.rtt = handshakeRTT(info.Conn)
+			r.mss = connMSS(info.Conn)
+			r.client = clientSide(info.Conn)
+			r.addr = originAddr(info.Conn)
+			s.dumpf("Connected to %s (reused %t)", info.Conn.RemoteAddr(),
+				info.Reused)
+			if t, ok := info.Conn.(*tls.Conn); ok {
+				state := t.ConnectionState()
+				r.tls = verifyTLS(state, state.ServerName)
+				s.dumpf("TLS %s with SNI %q %s", tls.VersionName(state.Version),
+					state.ServerName, r.tls)
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			s.dumpf("Wrote request")
+		},
+		GotFirstResponseByte: func() {
+			s.dumpf("Got first response byte")
+		},
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if r.interim != "" {
+				r.interim += "/"
+			}
+			r.interim += strconv.Itoa(code)
+			s.dumpf("< %d (interim)", code)
+			s.dumpHeaders("<", http.Header(header))
+			return nil
+		},
 	}
-	respNoVia, err := client.Do(req)
-	if *dump {
-		fmt.Printf("%#v\n", respNoVia)
+	var pt phaseTimer
+	pt.trace(trace)
+	ctx, cancel := withTimeout(s.withSNI(req.Context()), s.cfg.timeouts.request)
+	defer cancel()
+	traced := req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	s.dumpf("> %s %s", req.Method, req.URL)
+	s.dumpf("> Host: %s", req.Host)
+	s.dumpHeaders(">", req.Header)
+	s.cfg.rateLimits.wait(s.origin)
+	s.cfg.backoffs.wait(s.origin)
+	r.times.start = time.Now()
+	s.cfg.used.request(s.running)
+	resp, err := client.Do(traced)
+	r.times.headers = time.Now()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = errRequestTimeout
 	}
 	if err != nil {
-		s.logf(l, "HTTP request %#v failed: %s", req, err)
-		return
+		s.logf(l, "HTTP %s %s failed: %s", req.Method, req.URL, err)
+		r.tls = tlsFailure(err)
+		r.phases = pt.phases(r.times, false)
+		return r, err
 	}
-	s.noVia.yesno = true
-	sizeNoVia := 0
-	if respNoVia != nil && respNoVia.Body != nil {
-		b, _ := ioutil.ReadAll(respNoVia.Body)
-		sizeNoVia = len(b)
-		respNoVia.Body.Close()
+	s.dumpf("< %s %s", resp.Proto, resp.Status)
+	s.cfg.backoffs.record(s.origin, resp)
+
+	// HTTP/3 connections are not reported to GotConn so their
+	// certificates are checked here instead
+
+	if resp.ProtoMajor == 3 && resp.TLS != nil {
+		r.tls = verifyTLS(*resp.TLS, resp.TLS.ServerName)
+		s.dumpf("TLS %s with SNI %q %s", tls.VersionName(resp.TLS.Version),
+			resp.TLS.ServerName, r.tls)
 	}
-	s.noViaSize = sizeNoVia
-	s.noViaEncoding = respNoVia.Header.Get("Content-Encoding")
-	s.noViaServer = respNoVia.Header.Get("Server")
-	transport.CloseIdleConnections()
+	s.dumpHeaders("<", resp.Header)
+	var body []byte
+	if req.Method == http.MethodHead {
+		// A response to HEAD has no body so its size is the one it
+		// gives and nothing else about the body can be compared
 
-	// Now add the Via header to the same request and repeate
+		resp.Body.Close()
+		if resp.ContentLength > 0 {
+			r.size = int(resp.ContentLength)
+		}
+		r.sampled = true
+	} else if resp.Body != nil {
+		var timer *time.Timer
+		if s.cfg.timeouts.bodyRead > 0 {
+			timer = time.AfterFunc(s.cfg.timeouts.bodyRead, func() {
+				resp.Body.Close()
+			})
+		}
 
-	req.Header.Set("Via", "viascan 1.0")
+		if s.cfg.sampleBytes > 0 {
+			body, r.size, r.sampled = s.sampleBody(l, client, req, resp)
+		} else {
+			body, r.resumed = s.readBody(l, client, req, resp)
+			r.size = len(body)
+		}
+		s.dumpf("Read %d byte body", r.size)
 
-	s.via.ran = true
-	if *dump {
-		fmt.Printf("%#v\n", req)
+		if timer != nil && !timer.Stop() {
+			s.logf(l, "Timed out reading body after %d bytes", r.size)
+			r.phases = pt.phases(r.times, true)
+			return r, errBodyTimeout
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			s.logf(l, "Timed out making request after reading %d bytes", r.size)
+			r.phases = pt.phases(r.times, true)
+			return r, errRequestTimeout
+		}
 	}
-	respVia, err := client.Do(req)
-	if *dump {
-		fmt.Printf("%#v\n", respVia)
+	r.times.done = time.Now()
+	r.phases = pt.phases(r.times, true)
+	encoding := strings.Join(resp.Header.Values("Content-Encoding"), ",")
+	r.text, err = s.cfg.textSum(body, resp.Header.Get("Content-Type"), encoding)
+	r.hasText = err == nil && !r.sampled
+	if err == errBomb {
+		r.bomb = true
+		s.logf(l, "Decompression bomb suspected in %d byte body", r.size)
 	}
-	if err != nil {
-		s.logf(l, "HTTP request %#v failed: %s", req, err)
-		return
+	r.encoding = normalEncoding(encoding)
+	if s.cfg.dictionary && !r.sampled {
+		r.dictionary = s.cfg.offer(resp.Header, body, encoding)
 	}
-	s.via.yesno = true
-	sizeVia := 0
-	if respVia != nil && respVia.Body != nil {
-		b, _ := ioutil.ReadAll(respVia.Body)
-		sizeVia = len(b)
-		respVia.Body.Close()
-	}
-	s.viaSize = sizeVia
-	s.viaEncoding = respVia.Header.Get("Content-Encoding")
-	s.viaServer = respVia.Header.Get("Server")
-	transport.CloseIdleConnections()
+	if !r.sampled {
+		r.layers = s.cfg.checkLayers(body, encoding)
+		sum := sha256.Sum256(body)
+		r.hash = hex.EncodeToString(sum[:])
+	}
+	r.server = strings.Join(resp.Header.Values("Server"), ", ")
+	r.lists = lists(resp.Header)
+	r.cache = cacheHeader(resp.Header)
+	r.proto = resp.Proto
+	r.framing = framing(resp)
+	r.status = resp.StatusCode
+	r.redirects, r.final = redirectChain(resp)
+	if transport != s.cfg.pools[s.proto] {
+		client.CloseIdleConnections()
+	}
+
+	return r, nil
 }
 
-// logf writes to the log file prefixing with the origin being logged
+// logf writes to the log file, and to stderr with -verbose, prefixing
+// with the origin being logged
 func (s *site) logf(f *os.File, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
 	if f != nil {
-		fmt.Fprintf(f, fmt.Sprintf(s.origin+": "+format+"\n", a...))
+		fmt.Fprintf(f, "%s: %s\n", s.origin, msg)
 	}
+	infof("%s: %s\n", s.origin, msg)
+	s.dumpf("%s", msg)
 }
 
 // fields returns the list of fields that String() will return for a
 // site
 func (s *site) fields() string {
-	return "origin,host,resolves,noVia,via,noViaSize,viaSize,noViaEncoding,viaEncoding,noViaServer,viaServer"
+	return "origin,host,resolves,noVia,via,noViaSize,viaSize,noViaEncoding,viaEncoding,noViaServer,viaServer,probe,noViaNoCacheChanged,viaNoCacheChanged,followedFrom,protoDivergence,textDiffers,noViaFraming,viaFraming,framingDiffers,noViaReused,viaReused,noViaAEOrder,viaAEOrder,aeOrderViaOnly,verdict,cdnLoop,noViaResumed,viaResumed,noViaSampled,viaSampled,tlsError,failure,noViaProto,viaProto,noViaStatus,viaStatus,noViaLayers,viaLayers,layersViaOnly,lastVerdict,lastSeen,changed,timings,probeGap,forwarded,forwardedLikeVia,trigger,noViaHash,viaHash,bodiesIdentical,noViaPhases,viaPhases,triedOrigins,noViaWebSocket,viaWebSocket,noViaDictionary,viaDictionary,profile,noViaRTT,viaRTT,noViaEcho,viaEcho,headerDiffs,noViaInterim,viaInterim,noViaMSS,viaMSS,pmtuFailure,resolution,ip,family,risk,riskFactors,resolvedIPs,cnameChain,fingerprint,noViaClient,viaClient,proxy,acceptEncoding,noViaAEMatrix,viaAEMatrix,aeMatrixDiffers,noViaRedirects,viaRedirects,noViaFinalURL,viaFinalURL,redirectDiffers,noViaUA,viaUA"
 }
 
-func (s *site) String() string {
-	return fmt.Sprintf("%s,%s,%s,%s,%s,%d,%d,%s,%s,%s,%s", s.origin, s.host,
-		s.resolves, s.noVia, s.via, s.noViaSize, s.viaSize, s.noViaEncoding,
-		s.viaEncoding, s.noViaServer, s.viaServer)
+// values returns the values of the fields of s in the same order as
+// fields
+func (s *site) values() []interface{} {
+	return []interface{}{s.origin, s.host, s.resolves, s.noVia, s.via,
+		s.noViaSize, s.viaSize, s.noViaEncoding, s.viaEncoding,
+		s.noViaServer, s.viaServer, s.probe, s.noViaNoCacheChanged,
+		s.viaNoCacheChanged, s.followedFrom, s.protoDivergence,
+		s.textDiffers, s.noViaFraming, s.viaFraming,
+		s.framingDiffers, s.noViaReused, s.viaReused,
+		s.noViaAEOrder, s.viaAEOrder, s.aeOrderViaOnly,
+		s.verdict(), s.cdnLoop, s.noViaResumed, s.viaResumed,
+		s.noViaSampled, s.viaSampled, s.tlsError, s.failure,
+		s.noViaProto, s.viaProto, s.noViaStatus, s.viaStatus,
+		s.noViaLayers, s.viaLayers, s.layersViaOnly, s.lastVerdict,
+		s.lastSeen, s.changed, s.timings, s.probeGap, s.forwarded,
+		s.forwardedLikeVia, s.trigger, s.noViaHash, s.viaHash,
+		s.bodiesIdentical, s.noViaPhases, s.viaPhases, s.tried(),
+		s.noViaWebSocket, s.viaWebSocket, s.noViaDictionary,
+		s.viaDictionary, s.profile, s.noViaRTT, s.viaRTT,
+		s.noViaEcho, s.viaEcho, s.headerDiffs, s.noViaInterim,
+		s.viaInterim, s.noViaMSS, s.viaMSS, s.pmtuFailure,
+		s.resolution, s.addr, s.family(), s.risk, s.riskFactors,
+		joinIPs(s.resolvedIPs), strings.Join(s.cnames, "/"),
+		s.cfg.clientFingerprint, s.noViaClient, s.viaClient,
+		s.cfg.proxyName(),
+		strings.Join(codings(s.encoding), "/"), s.noViaAEMatrix,
+		s.viaAEMatrix, s.aeMatrixDiffers, s.noViaRedirects,
+		s.viaRedirects, s.noViaFinalURL, s.viaFinalURL,
+		s.redirectDiffers, s.noViaUA, s.viaUA}
 }
 
-var wg sync.WaitGroup
-
-func worker(work, result chan *site, l *os.File) {
-	for s := range work {
-		s.test(l)
-		result <- s
-	}
-	wg.Done()
+func (s *site) String() string {
+	return s.csv(',')
 }
 
-func writer(result chan *site, stop chan struct{}, fields bool) {
-	first := true
-	for s := range result {
-		if fields && first {
-			fmt.Printf("%s\n", s.fields())
-			first = false
-		}
-
-		fmt.Printf("%s\n", s)
+// verdict summarizes how a site behaves with Via: unresolved,
+// allowlisted (every request was reset or refused with 403 whether or
+// not it had Via), failed (the request with no Via did not work),
+// blocked (only the request with Via failed or got a 403),
+// decompression_bomb_suspected, encoding or size (the responses differ)
+// or identical
+func (s *site) verdict() string {
+	switch {
+	case !s.resolves.yesno:
+		return "unresolved"
+	case s.allowlisted:
+		return "allowlisted"
+	case !s.noVia.yesno:
+		return "failed"
+	case !s.via.yesno || s.forbidden():
+		return "blocked"
+	case s.bombSuspected:
+		return "decompression_bomb_suspected"
+	case s.noViaEncoding != s.viaEncoding:
+		return "encoding"
+	case s.noViaSize != s.viaSize:
+		return "size"
 	}
-	close(stop)
-}
-
-func main() {
-	resolver := flag.String("resolver", "127.0.0.1", "DNS resolver address")
-	dump = flag.Bool("dump", false, "Dump requests and responses for debugging")
-	fields := flag.Bool("fields", false,
-		"If set outputs a header line containing field names")
-	workers := flag.Int("workers", 10, "Number of concurrent workers")
-	log := flag.String("log", "", "File to write log information to")
-	flag.Parse()
 
-	resolverName = *resolver
-
-	if *workers < 1 {
-		fmt.Printf("-workers must be a positive number\n")
-		return
-	}
+	return "identical"
+}
 
-	var l *os.File
-	var err error
-	if *log != "" {
-		if l, err = os.Create(*log); err != nil {
-			fmt.Printf("Failed to create log file %s: %s\n", *log, err)
-			return
+func worker(p *workerPool, state *workerState) {
+	work, result, follow, l := p.work, p.result, p.follow, p.log
+	for !p.leave() {
+		s, ok := <-work
+		if !ok {
+			break
 		}
-		defer l.Close()
+		s.state = state
+		if s.cfg.dumpDir != "" {
+			s.dump = &siteDump{start: time.Now()}
+		}
+		s.testOrigins(l)
+		s.annotate(l)
+		s.verdictReached()
+		if err := s.saveDump(); err != nil {
+			s.logf(l, "Failed to write dump: %s", err)
+		}
+		state.set("", "")
+		if s.failure != "" {
+			atomic.AddInt64(&p.counts.failed, 1)
+		}
+		atomic.AddInt64(&p.counts.completed, 1)
+		s.cfg.dead.record(s)
+		follow.enqueue(s)
+		follow.enqueueIPs(s)
+		result <- s
+		follow.pending.Done()
 	}
+	p.removeState(state)
+	p.wg.Done()
+}
 
-	work := make(chan *site)
-	result := make(chan *site)
-	stop := make(chan struct{})
-
-	go writer(result, stop, *fields)
-
-	for i := 0; i < *workers; i++ {
-		wg.Add(1)
-		go worker(work, result, l)
+func writer(result chan *site, stop chan struct{}, budget *errorBudget,
+	p *pretty, roll *rollup, live *liveMetrics, hook *webhook,
+	store *resultDB, post *postProcessor, out sink) {
+	if p != nil {
+		p.header()
 	}
-
-	scan := bufio.NewScanner(os.Stdin)
-	for scan.Scan() {
-		parts := strings.Split(scan.Text(), ",")
-		if len(parts) != 2 {
-			fmt.Printf("Bad line: %s\n", scan.Text())
+	for s := range result {
+		post.process(s)
+		if p != nil {
+			p.write(s)
 		} else {
-			work <- &site{host: parts[0], origin: parts[1]}
+			out.write(s)
 		}
+		budget.record(s)
+		roll.record(s)
+		live.record(s)
+		hook.result(s)
+		if err := store.write(s); err != nil {
+			warnf("Failed to write result to -output-sqlite: %s\n", err)
+		}
+		s.cfg.checkpoints.release(s.line)
 	}
-
-	close(work)
-	wg.Wait()
-	close(result)
-	<-stop
-
-	if scan.Err() != nil {
-		fmt.Printf("Error reading input: %s\n", scan.Err())
-		return
+	if p != nil {
+		p.end()
+	} else {
+		out.end()
 	}
+	close(stop)
 }
@@ -0,0 +1,135 @@
+package viascan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhook POSTs a JSON event to a URL for every result that matches
+// its filter and one when the scan completes (see -webhook-url).
+// Events are sent in the background in the order they happened so
+// that a slow receiver does not hold up the output: if it falls
+// webhookQueue events behind, result events are dropped and counted.
+// Failures are reported on stderr rather than stopping the scan.
+type webhook struct {
+	url     string
+	filter  []condition
+	client  *http.Client
+	events  chan interface{}
+	done    sync.WaitGroup
+	sites   int
+	matched int
+	dropped int // Result events not sent because events was full
+}
+
+// webhookQueue is the number of events waiting to be sent after which
+// result events are dropped
+const webhookQueue = 1000
+
+// resultEvent is sent for each result that matches the filter. Site
+// holds the fields written by -output=json.
+type resultEvent struct {
+	Event string          `json:"event"`
+	Time  string          `json:"time"`
+	Site  json.RawMessage `json:"site"`
+}
+
+// completeEvent is sent once the scan has finished
+type completeEvent struct {
+	Event   string `json:"event"`
+	Time    string `json:"time"`
+	Sites   int    `json:"sites"`
+	Matched int    `json:"matched"`
+	Dropped int    `json:"dropped"`
+	Aborted bool   `json:"aborted"`
+}
+
+// newWebhook creates a webhook for url sending the results that match
+// filter, a list of conditions such as verdict!=identical (see
+// parseConditions), or every result if filter is empty
+func newWebhook(url, filter string) (*webhook, error) {
+	w := &webhook{url: url, client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan interface{}, webhookQueue)}
+	if filter != "" {
+		var err error
+		if w.filter, err = parseConditions(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	w.done.Add(1)
+	go func() {
+		for e := range w.events {
+			if err := w.post(e); err != nil {
+				warnf("Webhook %s failed: %s\n", w.url, err)
+			}
+		}
+		w.done.Done()
+	}()
+
+	return w, nil
+}
+
+func (w *webhook) post(e interface{}) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+	return nil
+}
+
+// result sends an event for s if it matches the filter
+func (w *webhook) result(s *site) {
+	if w == nil {
+		return
+	}
+
+	w.sites++
+	for _, c := range w.filter {
+		if !c.matches(s) {
+			return
+		}
+	}
+	w.matched++
+	select {
+	case w.events <- resultEvent{Event: "result",
+		Site: json.RawMessage(s.json()),
+		Time: time.Now().UTC().Format(time.RFC3339)}:
+	default:
+		if w.dropped == 0 {
+			warnf("Webhook %s is %d events behind, dropping results\n", w.url,
+				webhookQueue)
+		}
+		w.dropped++
+	}
+}
+
+// complete sends the event for the end of the scan and waits for every
+// event to be sent
+func (w *webhook) complete(aborted bool) {
+	if w == nil {
+		return
+	}
+
+	if w.dropped > 0 {
+		warnf("Webhook %s dropped %d of %d results\n", w.url, w.dropped,
+			w.matched)
+	}
+	w.events <- completeEvent{Event: "complete", Sites: w.sites,
+		Matched: w.matched, Dropped: w.dropped, Aborted: aborted,
+		Time: time.Now().UTC().Format(time.RFC3339)}
+	close(w.events)
+	w.done.Wait()
+}
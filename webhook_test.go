@@ -0,0 +1,50 @@
+package viascan
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// A receiver that falls behind loses result events rather than holding
+// up the scan, and the complete event says how many
+func TestWebhookDrops(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var last completeEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		<-release
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		json.Unmarshal(b, &last)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	w, err := newWebhook(ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &site{cfg: newConfig(), origin: "192.0.2.1", host: "example.com"}
+	n := webhookQueue + 10
+	for i := 0; i < n; i++ {
+		w.result(s)
+	}
+	close(release)
+	w.complete(false)
+
+	// One event is being posted and webhookQueue are waiting when the
+	// others are dropped
+
+	if w.dropped < 9 || w.dropped > 10 {
+		t.Errorf("dropped %d of %d events", w.dropped, n)
+	}
+	if last.Event != "complete" || last.Matched != n ||
+		last.Dropped != w.dropped {
+		t.Errorf("got final event %+v", last)
+	}
+}
@@ -0,0 +1,71 @@
+package viascan
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// websocketGUID is appended to the key when computing the accept value
+// (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// testWebSocket repeats the request with no Via and with Via as a
+// WebSocket upgrade and reports whether each handshake succeeded
+func (s *site) testWebSocket(l *os.File, req *http.Request) (tri, tri) {
+	noVia := req.Clone(req.Context())
+	noVia.Header.Del("Via")
+	via := req.Clone(req.Context())
+	via.Header.Set("Via", s.viaToSend())
+
+	return tri{ran: true, yesno: s.upgrade(l, noVia)},
+		tri{ran: true, yesno: s.upgrade(l, via)}
+}
+
+// upgrade sends req asking to upgrade to WebSocket and returns true if
+// the origin answered 101 with the accept value for the key sent.
+// Upgrades need HTTP/1.1 so a transport limited to it is used whatever
+// the site's protocol, and redirects are not followed.
+func (s *site) upgrade(l *os.File, req *http.Request) bool {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		s.logf(l, "Failed to make WebSocket key: %s", err)
+		return false
+	}
+	k := base64.StdEncoding.EncodeToString(key)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", k)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	transport := s.cfg.newTransport("h1")
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+
+	s.dumpf("> %s %s (WebSocket upgrade)", req.Method, req.URL)
+	s.dumpHeaders(">", req.Header)
+	s.cfg.rateLimits.wait(s.origin)
+	s.cfg.backoffs.wait(s.origin)
+	s.cfg.used.request(s.running)
+	resp, err := client.Do(req.WithContext(s.withSNI(req.Context())))
+	if err != nil {
+		s.logf(l, "WebSocket upgrade %s failed: %s", req.URL, err)
+		return false
+	}
+	defer resp.Body.Close()
+	s.dumpf("< %s %s", resp.Proto, resp.Status)
+	s.dumpHeaders("<", resp.Header)
+	s.cfg.backoffs.record(s.origin, resp)
+
+	sum := sha1.Sum([]byte(k + websocketGUID))
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") &&
+		resp.Header.Get("Sec-WebSocket-Accept") ==
+			base64.StdEncoding.EncodeToString(sum[:])
+}